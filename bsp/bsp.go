@@ -3,6 +3,8 @@ package bsp
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"github.com/thinkofdeath/goquake/internal/cstring"
 	"github.com/thinkofdeath/goquake/vmath"
 	"io"
 )
@@ -10,22 +12,39 @@ import (
 const (
 	sizeTextureInfo = 4*6 + 4*2 + 4*2
 	sizeVertex      = 4 * 3
-	sizeEdge        = 2 + 2
 	sizePlane       = 4*3 + 4 + 4
-	sizeFace        = 2 + 2 + 4 + 2 + 2 + 4 + 4
 	sizeModel       = (4*3)*3 + 4*4 + 4 + 4 + 4
 )
 
 type File struct {
-	LightMaps   []byte
-	Textures    []*Texture
-	textureInfo []*TextureInfo
-	vertices    []vmath.Vector3
-	Edges       []Edge
-	ledges      []int
-	planes      []*plane
-	faces       []*Face
-	Models      []*Model
+	// Version is the version of the format the file was
+	// stored in, e.g. VersionQuake.
+	Version   int
+	Entities  []*Entity
+	LightMaps []byte
+	// ColouredLightMaps contains the RGB light maps for
+	// versions that support them, 3 bytes for each byte
+	// in LightMaps.
+	ColouredLightMaps []byte
+	visibility        []byte
+	Textures          []*Texture
+	textureInfo       []*TextureInfo
+	vertices          []vmath.Vector3
+	Edges             []Edge
+	ledges            []int
+	planes            []*Plane
+	faces             []*Face
+	faceList          []*Face
+	Nodes             []*Node
+	Leaves            []*Leaf
+	Models            []*Model
+	Hulls             [3]*Hull
+
+	// Information about the original file used to write
+	// unmodified parts of the file back exactly.
+	layout      *fileLayout
+	rawEntities []byte
+	rawTextures []byte
 }
 
 func ParseBSPFile(r *io.SectionReader) (bsp *File, err error) {
@@ -37,84 +56,94 @@ func ParseBSPFile(r *io.SectionReader) (bsp *File, err error) {
 		return
 	}
 
-	if header.Version != 29 {
+	switch header.Version {
+	case VersionQuake, VersionHalfLife, VersionBSP2, Version2PSB:
+		bsp.Version = int(header.Version)
+	default:
 		err = errors.New("unsupported version")
 		return
 	}
 
-	// Grab the light maps out of the file
-	r.Seek(int64(header.LightMaps.Offset), 0)
-	bsp.LightMaps = make([]byte, header.LightMaps.Size)
-	io.ReadFull(r, bsp.LightMaps)
-
-	// Textures
-	err = bsp.parseTextures(io.NewSectionReader(r, int64(header.WallTextures.Offset), 0xFFFFFF))
-	if err != nil {
-		return
-	}
-
-	// Texture Info
-	err = bsp.parseTextureInfo(
-		io.NewSectionReader(r, int64(header.TextureInfo.Offset), 0xFFFFFF),
-		int(header.TextureInfo.Size/sizeTextureInfo),
-	)
+	bsp.layout, err = readLayout(r, &header)
 	if err != nil {
 		return
 	}
 
-	// Vertices
-	err = bsp.parseVertices(
-		io.NewSectionReader(r, int64(header.Vertices.Offset), 0xFFFFFF),
-		int(header.Vertices.Size/sizeVertex),
-	)
+	// Entities
+	entities, err := readLump(r, header.Entities, "entities")
 	if err != nil {
 		return
 	}
-
-	// Edges
-	err = bsp.parseEdges(
-		io.NewSectionReader(r, int64(header.Edges.Offset), 0xFFFFFF),
-		int(header.Edges.Size/sizeEdge),
-	)
+	bsp.rawEntities = entities
+	bsp.Entities, err = ParseEntities(cstring.String(entities))
 	if err != nil {
 		return
 	}
 
-	// Ledges
-	ledges := make([]int32, header.Ledges.Size/4)
-	r.Seek(int64(header.Ledges.Offset), 0)
-	err = binary.Read(r, binary.LittleEndian, ledges)
+	// Grab the light maps out of the file
+	bsp.LightMaps, err = readLump(r, header.LightMaps, "lightmaps")
 	if err != nil {
 		return
 	}
-	bsp.ledges = make([]int, len(ledges))
-	for i, j := range ledges {
-		bsp.ledges[i] = int(j)
+	if bsp.Version == VersionHalfLife {
+		// Keep the coloured version and convert the light
+		// maps to the mono ones used by Quake
+		bsp.ColouredLightMaps = bsp.LightMaps
+		bsp.LightMaps = make([]byte, len(bsp.ColouredLightMaps)/3)
+		for i := range bsp.LightMaps {
+			rgb := bsp.ColouredLightMaps[i*3 : i*3+3]
+			bsp.LightMaps[i] = byte((int(rgb[0]) + int(rgb[1]) + int(rgb[2])) / 3)
+		}
 	}
 
-	// Planes
-	err = bsp.parsePlanes(
-		io.NewSectionReader(r, int64(header.Planes.Offset), 0xFFFFFF),
-		int(header.Planes.Size/sizePlane),
-	)
+	// Visibility lists are kept compressed until needed
+	bsp.visibility, err = readLump(r, header.VisibilityList, "visibility")
 	if err != nil {
 		return
 	}
 
-	// Planes
-	err = bsp.parseFaces(
-		io.NewSectionReader(r, int64(header.Faces.Offset), 0xFFFFFF),
-		int(header.Faces.Size/sizeFace),
-	)
-	if err != nil {
+	// Each lump is parsed in order such that the lumps it
+	// refers to have already been parsed
+	sizes := bsp.sizes()
+	lumps := []struct {
+		name  string
+		entry bspEntry
+		size  int
+		parse func(r *io.SectionReader, count int) error
+	}{
+		{"textures", header.WallTextures, 1, bsp.parseTextures},
+		{"texinfo", header.TextureInfo, sizeTextureInfo, bsp.parseTextureInfo},
+		{"vertices", header.Vertices, sizeVertex, bsp.parseVertices},
+		{"edges", header.Edges, sizes.edge, bsp.parseEdges},
+		{"ledges", header.Ledges, 4, bsp.parseLedges},
+		{"planes", header.Planes, sizePlane, bsp.parsePlanes},
+		{"clipnodes", header.ClipNodes, sizes.clipNode, bsp.parseClipNodes},
+		{"faces", header.Faces, sizes.face, bsp.parseFaces},
+		{"facelist", header.FaceList, sizes.faceList, bsp.parseFaceList},
+		{"leaves", header.Leaves, sizes.leaf, bsp.parseLeaves},
+		{"nodes", header.Nodes, sizes.node, bsp.parseNodes},
+		{"models", header.Models, sizeModel, bsp.parseModels},
+	}
+	for _, l := range lumps {
+		var lr *io.SectionReader
+		lr, err = lumpReader(r, l.entry, l.name)
+		if err != nil {
+			return
+		}
+		if l.entry.Size%int32(l.size) != 0 {
+			err = &LumpError{Lump: l.name, Index: -1, Reason: "size isn't a multiple of the element size"}
+			return
+		}
+		err = l.parse(lr, int(l.entry.Size)/l.size)
+		if err != nil {
+			return
+		}
+	}
+	if len(bsp.Models) == 0 {
+		err = &LumpError{Lump: "models", Index: -1, Reason: "no models"}
 		return
 	}
-
-	// Models
-	err = bsp.parseModels(
-		io.NewSectionReader(r, int64(header.Models.Offset), 0xFFFFFF),
-		int(header.Models.Size/sizeModel),
-	)
+	bsp.rawTextures, err = readLump(r, header.WallTextures, "textures")
 	if err != nil {
 		return
 	}
@@ -122,6 +151,36 @@ func ParseBSPFile(r *io.SectionReader) (bsp *File, err error) {
 	return
 }
 
+// LumpError is returned when a lump of the file is
+// invalid. Index is the element of the lump with the
+// problem or -1 if the problem is with the whole lump.
+type LumpError struct {
+	Lump   string
+	Index  int
+	Reason string
+}
+
+func (e *LumpError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("bsp: %s: %s", e.Lump, e.Reason)
+	}
+	return fmt.Sprintf("bsp: %s[%d]: %s", e.Lump, e.Index, e.Reason)
+}
+
+func lumpError(lump string, index int, format string, args ...interface{}) error {
+	return &LumpError{Lump: lump, Index: index, Reason: fmt.Sprintf(format, args...)}
+}
+
+// checkRange returns an error if the range of count
+// elements starting at start isn't within an array of the
+// passed length.
+func checkRange(lump string, index int, target string, start, count, length int) error {
+	if start < 0 || count < 0 || start+count > length {
+		return lumpError(lump, index, "%s %d-%d out of range (%d)", target, start, start+count, length)
+	}
+	return nil
+}
+
 type bspHeader struct {
 	Version        int32
 	Entities       bspEntry
@@ -146,12 +205,21 @@ type bspEntry struct {
 	Size   int32
 }
 
-// Trims the string to the first 0 byte
-func fromCString(b []byte) string {
-	for i := 0; i < len(b); i++ {
-		if b[i] == 0 {
-			return string(b[:i])
-		}
+// lumpReader returns a reader limited to the lump
+func lumpReader(r *io.SectionReader, e bspEntry, name string) (*io.SectionReader, error) {
+	if e.Offset < 0 || e.Size < 0 || int64(e.Offset)+int64(e.Size) > r.Size() {
+		return nil, lumpError(name, -1, "offset %d, size %d out of bounds", e.Offset, e.Size)
+	}
+	return io.NewSectionReader(r, int64(e.Offset), int64(e.Size)), nil
+}
+
+// Reads the whole of the lump into memory
+func readLump(r *io.SectionReader, e bspEntry, name string) ([]byte, error) {
+	lr, err := lumpReader(r, e, name)
+	if err != nil {
+		return nil, err
 	}
-	return string(b)
+	data := make([]byte, e.Size)
+	_, err = io.ReadFull(lr, data)
+	return data, err
 }
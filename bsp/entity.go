@@ -0,0 +1,198 @@
+package bsp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/thinkofdeath/goquake/vmath"
+	"strconv"
+	"strings"
+)
+
+// Entity is a single entity from the entities lump of
+// a map. The key/value pairs are kept in the order they
+// appear in the file.
+type Entity struct {
+	Keys   []string
+	Values []string
+}
+
+// Get returns the value of the key and whether the key
+// was present.
+func (e *Entity) Get(key string) (string, bool) {
+	for i, k := range e.Keys {
+		if k == key {
+			return e.Values[i], true
+		}
+	}
+	return "", false
+}
+
+// Value returns the value of the key or an empty string
+// if the key isn't set.
+func (e *Entity) Value(key string) string {
+	v, _ := e.Get(key)
+	return v
+}
+
+// Set sets the value of the key, adding the key if it
+// isn't already present.
+func (e *Entity) Set(key, value string) {
+	for i, k := range e.Keys {
+		if k == key {
+			e.Values[i] = value
+			return
+		}
+	}
+	e.Keys = append(e.Keys, key)
+	e.Values = append(e.Values, value)
+}
+
+// ClassName returns the classname of the entity
+func (e *Entity) ClassName() string {
+	return e.Value("classname")
+}
+
+// Origin returns the parsed origin of the entity
+func (e *Entity) Origin() (vmath.Vector3, bool) {
+	v, ok := e.Get("origin")
+	if !ok {
+		return vmath.Vector3{}, false
+	}
+	var o vmath.Vector3
+	if _, err := fmt.Sscan(v, &o.X, &o.Y, &o.Z); err != nil {
+		return vmath.Vector3{}, false
+	}
+	return o, true
+}
+
+// Angle returns the yaw of the entity in degrees. Quake
+// uses -1 and -2 to mean up and down respectively.
+func (e *Entity) Angle() (float32, bool) {
+	v, ok := e.Get("angle")
+	if !ok {
+		return 0, false
+	}
+	a, err := strconv.ParseFloat(strings.TrimSpace(v), 32)
+	if err != nil {
+		return 0, false
+	}
+	return float32(a), true
+}
+
+// Model returns the index of the brush model referenced
+// by the entity's model key (e.g. "*3"). Entities that
+// reference external models (progs/*.mdl) or no model
+// return false.
+func (e *Entity) Model() (int, bool) {
+	v, ok := e.Get("model")
+	if !ok || !strings.HasPrefix(v, "*") {
+		return 0, false
+	}
+	i, err := strconv.Atoi(v[1:])
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+// FindEntities returns all entities with the given classname
+func (bsp *File) FindEntities(className string) []*Entity {
+	var out []*Entity
+	for _, e := range bsp.Entities {
+		if e.ClassName() == className {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+var errEntityEOF = errors.New("unexpected end of entities")
+
+// ParseEntities parses entities in the text format used
+// by the entities lump.
+func ParseEntities(data string) ([]*Entity, error) {
+	var entities []*Entity
+	p := entityParser{data: data}
+	for {
+		tok, ok := p.next()
+		if !ok {
+			return entities, nil
+		}
+		if tok != "{" {
+			return nil, fmt.Errorf("expected '{' found %q", tok)
+		}
+
+		e := &Entity{}
+		for {
+			key, ok := p.next()
+			if !ok {
+				return nil, errEntityEOF
+			}
+			if key == "}" {
+				break
+			}
+			value, ok := p.next()
+			if !ok {
+				return nil, errEntityEOF
+			}
+			if value == "}" {
+				return nil, fmt.Errorf("missing value for key %q", key)
+			}
+			e.Keys = append(e.Keys, key)
+			e.Values = append(e.Values, value)
+		}
+		entities = append(entities, e)
+	}
+}
+
+// entityParser tokenizes entity text in the same way as
+// Quake's COM_Parse.
+type entityParser struct {
+	data string
+	pos  int
+}
+
+func (p *entityParser) next() (string, bool) {
+	// Skip whitespace and comments
+	for {
+		for p.pos < len(p.data) && p.data[p.pos] <= ' ' {
+			p.pos++
+		}
+		if p.pos >= len(p.data) {
+			return "", false
+		}
+		if strings.HasPrefix(p.data[p.pos:], "//") {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+
+	switch c := p.data[p.pos]; c {
+	case '"':
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] != '"' {
+			p.pos++
+		}
+		tok := p.data[start:p.pos]
+		// Skip the closing quote
+		p.pos++
+		return tok, true
+	case '{', '}':
+		p.pos++
+		return string(c), true
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c <= ' ' || c == '"' || c == '{' || c == '}' {
+			break
+		}
+		p.pos++
+	}
+	return p.data[start:p.pos], true
+}
@@ -1,54 +1,135 @@
 package bsp
 
 import (
-	"encoding/binary"
+	"github.com/thinkofdeath/goquake/vmath"
 	"io"
+	"math"
 )
 
 type Face struct {
-	plane       *plane
-	front       bool
+	Plane       *Plane
+	Front       bool
 	Ledges      []int
 	TextureInfo *TextureInfo
-	TypeLight   uint8
-	BaseLight   uint8
-	light       [2]uint8
-	LightMap    int32
+	// Styles contains the light style of each of the
+	// face's light maps, unused styles are NoStyle.
+	Styles [4]uint8
+	// LightMap is the offset of the face's light maps in
+	// LightMaps or -1. The light maps for each style are
+	// stored one after another.
+	LightMap int32
+
+	// Original values kept for writing the file
+	ledgeID int
+	side    int32
+}
+
+// NoStyle marks an unused light style of a face
+const NoStyle = 255
+
+// LightMapCount returns the number of light maps the face
+// has, one for each style in use.
+func (f *Face) LightMapCount() int {
+	if f.LightMap == -1 {
+		return 0
+	}
+	for i, s := range f.Styles {
+		if s == NoStyle {
+			return i
+		}
+	}
+	return len(f.Styles)
+}
+
+// LightMapSize returns the size of each of the face's
+// light maps. There is a sample every 16 texels across
+// the face's texture extents.
+func (bsp *File) LightMapSize(f *Face) (width, height int) {
+	if len(f.Ledges) == 0 {
+		return 0, 0
+	}
+	minS := math.Inf(1)
+	minT := math.Inf(1)
+	maxS := math.Inf(-1)
+	maxT := math.Inf(-1)
+
+	tInfo := f.TextureInfo
+	for _, l := range f.Ledges {
+		var vert *vmath.Vector3
+		if l < 0 {
+			vert = bsp.Edges[-l].Vertex1
+		} else {
+			vert = bsp.Edges[l].Vertex0
+		}
+
+		valS := float64(vert.Dot(tInfo.VectorS) + tInfo.DistS)
+		valT := float64(vert.Dot(tInfo.VectorT) + tInfo.DistT)
+
+		minS = math.Min(minS, valS)
+		maxS = math.Max(maxS, valS)
+		minT = math.Min(minT, valT)
+		maxT = math.Max(maxT, valT)
+	}
+
+	width = int(math.Ceil(maxS/16)-math.Floor(minS/16)) + 1
+	height = int(math.Ceil(maxT/16)-math.Floor(minT/16)) + 1
+	return width, height
 }
 
 type faceData struct {
-	PlaneID   uint16
-	Side      uint16
-	LedgeId   int32
-	LedgeNum  uint16
-	TexInfoID uint16
-	TypeLight uint8
-	BaseLight uint8
-	Light     [2]uint8
+	PlaneID   int32
+	Side      int32
+	LedgeID   int32
+	LedgeNum  int32
+	TexInfoID int32
+	Styles    [4]uint8
 	LightMap  int32
 }
 
 func (bsp *File) parseFaces(r *io.SectionReader, count int) error {
 	bsp.faces = make([]*Face, count)
 
-	faces := make([]faceData, count)
-	err := binary.Read(r, binary.LittleEndian, faces)
+	faces, err := bsp.readFaces(r, count)
 	if err != nil {
 		return err
 	}
 
 	for i := 0; i < count; i++ {
 		f := faces[i]
-		bsp.faces[i] = &Face{
-			plane:       bsp.planes[f.PlaneID],
-			front:       f.Side == 0,
-			Ledges:      bsp.ledges[f.LedgeId : f.LedgeId+int32(f.LedgeNum)],
+		if f.PlaneID < 0 || int(f.PlaneID) >= len(bsp.planes) {
+			return lumpError("faces", i, "plane %d out of range (%d)", f.PlaneID, len(bsp.planes))
+		}
+		if err := checkRange("faces", i, "ledges", int(f.LedgeID), int(f.LedgeNum), len(bsp.ledges)); err != nil {
+			return err
+		}
+		if f.TexInfoID < 0 || int(f.TexInfoID) >= len(bsp.textureInfo) {
+			return lumpError("faces", i, "texinfo %d out of range (%d)", f.TexInfoID, len(bsp.textureInfo))
+		}
+		if bsp.Version == VersionHalfLife && f.LightMap > 0 {
+			// Half-Life's offsets are into the coloured
+			// light maps
+			f.LightMap /= 3
+		}
+		if f.LightMap < -1 || int(f.LightMap) >= len(bsp.LightMaps) {
+			return lumpError("faces", i, "lightmap %d out of range (%d)", f.LightMap, len(bsp.LightMaps))
+		}
+		face := &Face{
+			Plane:       bsp.planes[f.PlaneID],
+			Front:       f.Side == 0,
+			Ledges:      bsp.ledges[f.LedgeID : int(f.LedgeID)+int(f.LedgeNum)],
 			TextureInfo: bsp.textureInfo[f.TexInfoID],
-			TypeLight:   f.TypeLight,
-			BaseLight:   f.BaseLight,
-			light:       f.Light,
+			Styles:      f.Styles,
 			LightMap:    f.LightMap,
+			ledgeID:     int(f.LedgeID),
+			side:        f.Side,
+		}
+		if count := face.LightMapCount(); count > 0 {
+			w, h := bsp.LightMapSize(face)
+			if size := w * h * count; int(f.LightMap)+size > len(bsp.LightMaps) {
+				return lumpError("faces", i, "lightmap %d+%d out of range (%d)", f.LightMap, size, len(bsp.LightMaps))
+			}
 		}
+		bsp.faces[i] = face
 	}
 	return nil
 }
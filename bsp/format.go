@@ -0,0 +1,240 @@
+package bsp
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Versions of the format that can be parsed
+const (
+	// VersionQuake is the version used by Quake
+	VersionQuake = 29
+	// VersionHalfLife is the version used by Half-Life. The
+	// lighting is coloured and each texture has its own
+	// palette.
+	VersionHalfLife = 30
+	// VersionBSP2 is an extension of VersionQuake with 32
+	// bit indices and floating point bounds for large maps.
+	VersionBSP2 = 'B' | 'S'<<8 | 'P'<<16 | '2'<<24
+	// Version2PSB is an older version of VersionBSP2 which
+	// kept the 16 bit bounds.
+	Version2PSB = '2' | 'P'<<8 | 'S'<<16 | 'B'<<24
+)
+
+// Element sizes of the lumps that differ between versions
+type lumpSizes struct {
+	edge, clipNode, face, faceList, leaf, node int
+}
+
+var (
+	sizesQuake = lumpSizes{
+		edge:     2 + 2,
+		clipNode: 4 + 2*2,
+		face:     2 + 2 + 4 + 2 + 2 + 4 + 4,
+		faceList: 2,
+		leaf:     4 + 4 + 2*3*2 + 2 + 2 + 4,
+		node:     4 + 2*2 + 2*3*2 + 2 + 2,
+	}
+	sizesBSP2 = lumpSizes{
+		edge:     4 + 4,
+		clipNode: 4 + 4*2,
+		face:     4*5 + 4 + 4,
+		faceList: 4,
+		leaf:     4 + 4 + 4*3*2 + 4 + 4 + 4,
+		node:     4 + 4*2 + 4*3*2 + 4 + 4,
+	}
+	sizes2PSB = lumpSizes{
+		edge:     sizesBSP2.edge,
+		clipNode: sizesBSP2.clipNode,
+		face:     sizesBSP2.face,
+		faceList: sizesBSP2.faceList,
+		leaf:     4 + 4 + 2*3*2 + 4 + 4 + 4,
+		node:     4 + 4*2 + 2*3*2 + 4 + 4,
+	}
+)
+
+func (bsp *File) sizes() lumpSizes {
+	switch bsp.Version {
+	case VersionBSP2:
+		return sizesBSP2
+	case Version2PSB:
+		return sizes2PSB
+	}
+	return sizesQuake
+}
+
+// extended returns whether the file uses 32 bit indices
+func (bsp *File) extended() bool {
+	return bsp.Version == VersionBSP2 || bsp.Version == Version2PSB
+}
+
+// The lump readers below read each version's layout and
+// widen it to a single layout used for parsing.
+
+func (bsp *File) readEdges(r io.Reader, count int) ([]edgeData, error) {
+	edges := make([]edgeData, count)
+	if bsp.extended() {
+		return edges, binary.Read(r, binary.LittleEndian, edges)
+	}
+	short := make([][2]uint16, count)
+	err := binary.Read(r, binary.LittleEndian, short)
+	for i, e := range short {
+		edges[i] = edgeData{uint32(e[0]), uint32(e[1])}
+	}
+	return edges, err
+}
+
+func (bsp *File) readClipNodes(r io.Reader, count int) ([]clipNodeData, error) {
+	nodes := make([]clipNodeData, count)
+	if bsp.extended() {
+		return nodes, binary.Read(r, binary.LittleEndian, nodes)
+	}
+	short := make([]struct {
+		PlaneID  int32
+		Children [2]int16
+	}, count)
+	err := binary.Read(r, binary.LittleEndian, short)
+	for i, n := range short {
+		nodes[i] = clipNodeData{
+			PlaneID:  n.PlaneID,
+			Children: [2]int32{int32(n.Children[0]), int32(n.Children[1])},
+		}
+	}
+	return nodes, err
+}
+
+func (bsp *File) readFaces(r io.Reader, count int) ([]faceData, error) {
+	faces := make([]faceData, count)
+	if bsp.extended() {
+		return faces, binary.Read(r, binary.LittleEndian, faces)
+	}
+	short := make([]struct {
+		PlaneID   uint16
+		Side      uint16
+		LedgeID   int32
+		LedgeNum  uint16
+		TexInfoID uint16
+		Styles    [4]uint8
+		LightMap  int32
+	}, count)
+	err := binary.Read(r, binary.LittleEndian, short)
+	for i, f := range short {
+		faces[i] = faceData{
+			PlaneID:   int32(f.PlaneID),
+			Side:      int32(f.Side),
+			LedgeID:   f.LedgeID,
+			LedgeNum:  int32(f.LedgeNum),
+			TexInfoID: int32(f.TexInfoID),
+			Styles:    f.Styles,
+			LightMap:  f.LightMap,
+		}
+	}
+	return faces, err
+}
+
+func (bsp *File) readFaceList(r io.Reader, count int) ([]uint32, error) {
+	list := make([]uint32, count)
+	if bsp.extended() {
+		return list, binary.Read(r, binary.LittleEndian, list)
+	}
+	short := make([]uint16, count)
+	err := binary.Read(r, binary.LittleEndian, short)
+	for i, f := range short {
+		list[i] = uint32(f)
+	}
+	return list, err
+}
+
+func (bsp *File) readLeaves(r io.Reader, count int) ([]leafData, error) {
+	leaves := make([]leafData, count)
+	if bsp.Version == VersionBSP2 {
+		return leaves, binary.Read(r, binary.LittleEndian, leaves)
+	}
+	if bsp.Version == Version2PSB {
+		short := make([]struct {
+			Contents    int32
+			VisOffset   int32
+			Min, Max    [3]int16
+			FaceListID  uint32
+			FaceListNum uint32
+			Ambient     [4]uint8
+		}, count)
+		err := binary.Read(r, binary.LittleEndian, short)
+		for i, l := range short {
+			leaves[i] = leafData{
+				Contents:    l.Contents,
+				VisOffset:   l.VisOffset,
+				Bound:       shortBound(l.Min, l.Max),
+				FaceListID:  l.FaceListID,
+				FaceListNum: l.FaceListNum,
+				Ambient:     l.Ambient,
+			}
+		}
+		return leaves, err
+	}
+	short := make([]struct {
+		Contents    int32
+		VisOffset   int32
+		Min, Max    [3]int16
+		FaceListID  uint16
+		FaceListNum uint16
+		Ambient     [4]uint8
+	}, count)
+	err := binary.Read(r, binary.LittleEndian, short)
+	for i, l := range short {
+		leaves[i] = leafData{
+			Contents:    l.Contents,
+			VisOffset:   l.VisOffset,
+			Bound:       shortBound(l.Min, l.Max),
+			FaceListID:  uint32(l.FaceListID),
+			FaceListNum: uint32(l.FaceListNum),
+			Ambient:     l.Ambient,
+		}
+	}
+	return leaves, err
+}
+
+func (bsp *File) readNodes(r io.Reader, count int) ([]nodeData, error) {
+	nodes := make([]nodeData, count)
+	if bsp.Version == VersionBSP2 {
+		return nodes, binary.Read(r, binary.LittleEndian, nodes)
+	}
+	if bsp.Version == Version2PSB {
+		short := make([]struct {
+			PlaneID  int32
+			Children [2]int32
+			Min, Max [3]int16
+			FaceID   uint32
+			FaceNum  uint32
+		}, count)
+		err := binary.Read(r, binary.LittleEndian, short)
+		for i, n := range short {
+			nodes[i] = nodeData{
+				PlaneID:  n.PlaneID,
+				Children: n.Children,
+				Bound:    shortBound(n.Min, n.Max),
+				FaceID:   n.FaceID,
+				FaceNum:  n.FaceNum,
+			}
+		}
+		return nodes, err
+	}
+	short := make([]struct {
+		PlaneID  int32
+		Children [2]int16
+		Min, Max [3]int16
+		FaceID   uint16
+		FaceNum  uint16
+	}, count)
+	err := binary.Read(r, binary.LittleEndian, short)
+	for i, n := range short {
+		nodes[i] = nodeData{
+			PlaneID:  n.PlaneID,
+			Children: [2]int32{int32(n.Children[0]), int32(n.Children[1])},
+			Bound:    shortBound(n.Min, n.Max),
+			FaceID:   uint32(n.FaceID),
+			FaceNum:  uint32(n.FaceNum),
+		}
+	}
+	return nodes, err
+}
@@ -0,0 +1,279 @@
+package bsp
+
+import (
+	"github.com/thinkofdeath/goquake/vmath"
+	"io"
+)
+
+// Hull types used for collision. Each hull is the level
+// expanded by the size of the object moving through it
+// allowing the object to be traced as a point.
+const (
+	// HullPoint is used for points and projectiles
+	HullPoint = iota
+	// HullPlayer is used for players and most monsters
+	HullPlayer
+	// HullLarge is used for large monsters such as the
+	// shambler
+	HullLarge
+)
+
+// Distance to keep traces away from the planes they hit
+const distEpsilon = 0.03125
+
+// ClipNode is a node in a collision hull.
+type ClipNode struct {
+	Plane *Plane
+	// Children contains the front and back children of
+	// the node. Positive values are indices into the hull's
+	// clip nodes and negative values are the Contents of
+	// the space.
+	Children [2]int
+}
+
+// Hull is a bsp tree used for collision.
+type Hull struct {
+	ClipNodes []*ClipNode
+	// The size of the box this hull collides with
+	Min, Max vmath.Vector3
+}
+
+type clipNodeData struct {
+	PlaneID  int32
+	Children [2]int32
+}
+
+func (bsp *File) parseClipNodes(r *io.SectionReader, count int) error {
+	nodes, err := bsp.readClipNodes(r, count)
+	if err != nil {
+		return err
+	}
+
+	clipNodes := make([]*ClipNode, count)
+	for i, n := range nodes {
+		if n.PlaneID < 0 || int(n.PlaneID) >= len(bsp.planes) {
+			return lumpError("clipnodes", i, "plane %d out of range (%d)", n.PlaneID, len(bsp.planes))
+		}
+		for _, c := range n.Children {
+			if c >= 0 && int(c) >= count {
+				return lumpError("clipnodes", i, "child %d out of range (%d)", c, count)
+			}
+		}
+		clipNodes[i] = &ClipNode{
+			Plane:    bsp.planes[n.PlaneID],
+			Children: [2]int{int(n.Children[0]), int(n.Children[1])},
+		}
+	}
+
+	// The larger hulls share clip nodes, each model has a
+	// different head node into them.
+	bsp.Hulls[HullPlayer] = &Hull{
+		ClipNodes: clipNodes,
+		Min:       vmath.Vector3{X: -16, Y: -16, Z: -24},
+		Max:       vmath.Vector3{X: 16, Y: 16, Z: 32},
+	}
+	bsp.Hulls[HullLarge] = &Hull{
+		ClipNodes: clipNodes,
+		Min:       vmath.Vector3{X: -32, Y: -32, Z: -24},
+		Max:       vmath.Vector3{X: 32, Y: 32, Z: 64},
+	}
+	if bsp.Version == VersionHalfLife {
+		// Half-Life's hulls are centered on the origin. Its
+		// fourth (crouching) hull isn't supported.
+		bsp.Hulls[HullPlayer].Min = vmath.Vector3{X: -16, Y: -16, Z: -36}
+		bsp.Hulls[HullPlayer].Max = vmath.Vector3{X: 16, Y: 16, Z: 36}
+		bsp.Hulls[HullLarge].Min = vmath.Vector3{X: -32, Y: -32, Z: -32}
+		bsp.Hulls[HullLarge].Max = vmath.Vector3{X: 32, Y: 32, Z: 32}
+	}
+	return nil
+}
+
+// makePointHull creates the point hull from the bsp tree
+// used for rendering.
+func (bsp *File) makePointHull() {
+	clipNodes := make([]*ClipNode, len(bsp.Nodes))
+	for i, n := range bsp.Nodes {
+		c := &ClipNode{Plane: n.Plane}
+		for j, child := range n.Children {
+			if child < 0 {
+				child = int(bsp.Leaves[-(child + 1)].Contents)
+			}
+			c.Children[j] = child
+		}
+		clipNodes[i] = c
+	}
+	bsp.Hulls[HullPoint] = &Hull{ClipNodes: clipNodes}
+}
+
+// Contents returns the contents of the hull at the point
+// starting from the passed node.
+func (h *Hull) Contents(node int, p vmath.Vector3) Contents {
+	for node >= 0 {
+		n := h.ClipNodes[node]
+		if n.Plane.Distance(p) < 0 {
+			node = n.Children[1]
+		} else {
+			node = n.Children[0]
+		}
+	}
+	return Contents(node)
+}
+
+// TraceResult is the result of a trace through a hull.
+type TraceResult struct {
+	// AllSolid is set if the trace never left solid space
+	AllSolid bool
+	// StartSolid is set if the trace started in solid
+	// space
+	StartSolid bool
+	InOpen     bool
+	InWater    bool
+	// Fraction is how far along the trace got before
+	// hitting something, 1 being the whole way.
+	Fraction float32
+	EndPos   vmath.Vector3
+	// Plane is the plane that was hit, facing towards
+	// the start of the trace.
+	Plane Plane
+}
+
+// Trace moves a box of the hull's size from start to end
+// through the world model, stopping at the first solid
+// surface hit.
+func (bsp *File) Trace(start, end vmath.Vector3, hull int) TraceResult {
+	return bsp.TraceModel(bsp.Models[0], vmath.Vector3{}, start, end, hull)
+}
+
+// TraceModel is like Trace but traces against the model
+// positioned at origin.
+func (bsp *File) TraceModel(m *Model, origin, start, end vmath.Vector3, hull int) TraceResult {
+	trace := TraceResult{
+		Fraction: 1,
+		AllSolid: true,
+		EndPos:   end,
+	}
+	h := bsp.Hulls[hull]
+	if h == nil {
+		return trace
+	}
+	t := hullTrace{hull: h, head: m.HeadNodes[hull], trace: &trace}
+	t.check(t.head, 0, 1, start.Sub(origin), end.Sub(origin))
+	if trace.Fraction != 1 {
+		trace.EndPos = trace.EndPos.Add(origin)
+	}
+	return trace
+}
+
+// HullContents returns the contents of the world model's
+// hull at the point.
+func (bsp *File) HullContents(p vmath.Vector3, hull int) Contents {
+	h := bsp.Hulls[hull]
+	if h == nil {
+		return ContentsSolid
+	}
+	return h.Contents(bsp.Models[0].HeadNodes[hull], p)
+}
+
+type hullTrace struct {
+	hull  *Hull
+	head  int
+	trace *TraceResult
+}
+
+// check is a port of Quake's SV_RecursiveHullCheck. It
+// returns false once the trace has hit something.
+func (t *hullTrace) check(node int, p1f, p2f float32, p1, p2 vmath.Vector3) bool {
+	trace := t.trace
+	if node < 0 {
+		if Contents(node) != ContentsSolid {
+			trace.AllSolid = false
+			if Contents(node) == ContentsEmpty {
+				trace.InOpen = true
+			} else {
+				trace.InWater = true
+			}
+		} else {
+			trace.StartSolid = true
+		}
+		return true
+	}
+
+	n := t.hull.ClipNodes[node]
+	plane := n.Plane
+	t1 := plane.Distance(p1)
+	t2 := plane.Distance(p2)
+
+	if t1 >= 0 && t2 >= 0 {
+		return t.check(n.Children[0], p1f, p2f, p1, p2)
+	}
+	if t1 < 0 && t2 < 0 {
+		return t.check(n.Children[1], p1f, p2f, p1, p2)
+	}
+
+	// Put the cross point distEpsilon units on the near
+	// side
+	var frac float32
+	if t1 < 0 {
+		frac = (t1 + distEpsilon) / (t1 - t2)
+	} else {
+		frac = (t1 - distEpsilon) / (t1 - t2)
+	}
+	if frac < 0 {
+		frac = 0
+	}
+	if frac > 1 {
+		frac = 1
+	}
+
+	midf := p1f + (p2f-p1f)*frac
+	mid := p1.Lerp(p2, frac)
+
+	side := 0
+	if t1 < 0 {
+		side = 1
+	}
+
+	// Move up to the node
+	if !t.check(n.Children[side], p1f, midf, p1, mid) {
+		return false
+	}
+
+	if t.hull.Contents(n.Children[side^1], mid) != ContentsSolid {
+		// Go past the node
+		return t.check(n.Children[side^1], midf, p2f, mid, p2)
+	}
+
+	if trace.AllSolid {
+		// Never got out of the solid area
+		return false
+	}
+
+	// The other side of the node is solid so this is the
+	// impact point
+	if side == 0 {
+		trace.Plane = *plane
+	} else {
+		trace.Plane = Plane{Normal: plane.Normal.Scale(-1), Dist: -plane.Dist, Type: plane.Type}
+		// Axial planes can't have a negative normal
+		if trace.Plane.Type < 3 {
+			trace.Plane.Type += 3
+		}
+	}
+
+	for t.hull.Contents(t.head, mid) == ContentsSolid {
+		// Shouldn't really happen but floating point errors
+		// can leave the point in solid space so back up
+		frac -= 0.1
+		if frac < 0 {
+			trace.Fraction = midf
+			trace.EndPos = mid
+			return false
+		}
+		midf = p1f + (p2f-p1f)*frac
+		mid = p1.Lerp(p2, frac)
+	}
+
+	trace.Fraction = midf
+	trace.EndPos = mid
+	return false
+}
@@ -0,0 +1,45 @@
+package bsp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	litMagic   = "QLIT"
+	litVersion = 1
+)
+
+// ErrInvalidLit is returned when a .lit file is invalid or
+// doesn't match the map's light maps
+var ErrInvalidLit = errors.New("Invalid LIT file")
+
+// ParseLit reads coloured light maps from a .lit file,
+// which some maps ship alongside the bsp file (e.g.
+// maps/e1m1.lit), into ColouredLightMaps.
+func (bsp *File) ParseLit(r *io.SectionReader) error {
+	var header struct {
+		Magic   [4]byte
+		Version int32
+	}
+	err := binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return err
+	}
+	if string(header.Magic[:]) != litMagic || header.Version != litVersion {
+		return ErrInvalidLit
+	}
+	// There is an RGB triple for each sample of the mono
+	// light maps
+	size := r.Size() - int64(binary.Size(header))
+	if size != int64(len(bsp.LightMaps))*3 {
+		return ErrInvalidLit
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	bsp.ColouredLightMaps = data
+	return nil
+}
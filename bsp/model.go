@@ -7,13 +7,21 @@ import (
 )
 
 type Model struct {
-	bound  boundingBox
+	Bound  BoundingBox
 	Origin vmath.Vector3
 	Faces  []*Face
+	// HeadNodes contains the root nodes of the model's
+	// hulls. The first is an index into Nodes.
+	HeadNodes [4]int
+	// VisLeafs is the number of leaves in the model that
+	// are included in the visibility information.
+	VisLeafs int
+
+	faceID int
 }
 
 type modelData struct {
-	Bound       boundingBox
+	Bound       BoundingBox
 	Origin      vmath.Vector3
 	NodeID      [4]int32
 	NumberLeafs int32
@@ -21,7 +29,8 @@ type modelData struct {
 	FaceNum     int32
 }
 
-type boundingBox struct {
+// BoundingBox is an axis aligned box
+type BoundingBox struct {
 	Min vmath.Vector3
 	Max vmath.Vector3
 }
@@ -37,10 +46,29 @@ func (bsp *File) parseModels(r *io.SectionReader, count int) error {
 
 	for i := 0; i < count; i++ {
 		m := models[i]
+		if err := checkRange("models", i, "faces", int(m.FaceID), int(m.FaceNum), len(bsp.faces)); err != nil {
+			return err
+		}
+		if m.NodeID[0] < 0 || int(m.NodeID[0]) >= len(bsp.Nodes) {
+			return lumpError("models", i, "node %d out of range (%d)", m.NodeID[0], len(bsp.Nodes))
+		}
+		for _, n := range m.NodeID[1:] {
+			if int(n) >= len(bsp.Hulls[HullPlayer].ClipNodes) {
+				return lumpError("models", i, "clip node %d out of range (%d)", n, len(bsp.Hulls[HullPlayer].ClipNodes))
+			}
+		}
+		if m.NumberLeafs < 0 || int(m.NumberLeafs) > len(bsp.Leaves)-1 {
+			return lumpError("models", i, "vis leaf count %d out of range (%d)", m.NumberLeafs, len(bsp.Leaves)-1)
+		}
 		bsp.Models[i] = &Model{
-			bound:  m.Bound,
-			Origin: m.Origin,
-			Faces:  bsp.faces[m.FaceID : m.FaceID+m.FaceNum],
+			Bound:    m.Bound,
+			Origin:   m.Origin,
+			Faces:    bsp.faces[m.FaceID : m.FaceID+m.FaceNum],
+			VisLeafs: int(m.NumberLeafs),
+			faceID:   int(m.FaceID),
+		}
+		for j, n := range m.NodeID {
+			bsp.Models[i].HeadNodes[j] = int(n)
 		}
 	}
 	return nil
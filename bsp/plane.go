@@ -6,10 +6,15 @@ import (
 	"io"
 )
 
-type plane struct {
-	normal vmath.Vector3
-	dist   float32
-	t      int
+// Plane is a plane used for splitting the level and for
+// faces.
+type Plane struct {
+	Normal vmath.Vector3
+	Dist   float32
+	// Type is the axis the plane is aligned to. 0-2 are
+	// the X, Y and Z axes, 3-5 are planes that are only
+	// mostly aligned to them.
+	Type int
 }
 
 type planeData struct {
@@ -19,7 +24,7 @@ type planeData struct {
 }
 
 func (bsp *File) parsePlanes(r *io.SectionReader, count int) error {
-	bsp.planes = make([]*plane, count)
+	bsp.planes = make([]*Plane, count)
 
 	planes := make([]planeData, count)
 	err := binary.Read(r, binary.LittleEndian, planes)
@@ -29,11 +34,20 @@ func (bsp *File) parsePlanes(r *io.SectionReader, count int) error {
 
 	for i := 0; i < count; i++ {
 		p := planes[i]
-		bsp.planes[i] = &plane{
-			normal: p.Normal,
-			dist:   p.Dist,
-			t:      int(p.Type),
+		bsp.planes[i] = &Plane{
+			Normal: p.Normal,
+			Dist:   p.Dist,
+			Type:   int(p.Type),
 		}
 	}
 	return nil
 }
+
+// Distance returns the signed distance of the point from
+// the plane.
+func (p *Plane) Distance(point vmath.Vector3) float32 {
+	if p.Type < 3 {
+		return point.Index(p.Type) - p.Dist
+	}
+	return point.Dot(p.Normal) - p.Dist
+}
@@ -2,48 +2,141 @@ package bsp
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/thinkofdeath/goquake/internal/cstring"
+	"image"
 	"io"
+	"strings"
 )
 
+// ErrUnknownTexture is returned when replacing a texture
+// that isn't in the file.
+var ErrUnknownTexture = errors.New("unknown texture")
+
 type Texture struct {
 	ID            int
 	Name          string
 	Width, Height int
 	Pictures      [4]*Picture
+	// Palette is the texture's own palette (RGB triples)
+	// for versions that store one, otherwise nil. See
+	// RemapPalette.
+	Palette []byte
+	// AnimFrames contains the frames of the animation the
+	// texture is part of in order (+0 to +9 or +a to +j),
+	// nil if the texture isn't animated. Every texture in
+	// the animation shares the same slice.
+	AnimFrames []*Texture
+	// AlternateFrames contains the frames of the other
+	// animation with the same name (+a to +j for a +0 to +9
+	// texture and the reverse) which is used whilst an
+	// entity is in its alternate state, e.g. a pressed
+	// button. nil if there isn't one.
+	AlternateFrames []*Texture
 }
 
-func (bsp *File) parseTextures(r *io.SectionReader) error {
-	var count int32
-	err := binary.Read(r, binary.LittleEndian, &count)
+// parseTextures parses the textures lump, count is the
+// size of the lump in bytes.
+func (bsp *File) parseTextures(r *io.SectionReader, count int) error {
+	if count == 0 {
+		return nil
+	}
+	var num int32
+	err := binary.Read(r, binary.LittleEndian, &num)
 	if err != nil {
 		return err
 	}
+	if num < 0 || int64(num)*4+4 > r.Size() {
+		return lumpError("textures", -1, "texture count %d too large", num)
+	}
 
-	bsp.Textures = make([]*Texture, count)
-
-	for i := 0; i < int(count); i++ {
-		var offset int32
-		err = binary.Read(r, binary.LittleEndian, &offset)
-		if err != nil {
-			return err
-		}
+	offsets := make([]int32, num)
+	err = binary.Read(r, binary.LittleEndian, offsets)
+	if err != nil {
+		return err
+	}
 
+	bsp.Textures = make([]*Texture, num)
+	for i, offset := range offsets {
 		if offset == -1 {
 			continue
 		}
+		if offset < 0 || int64(offset) >= r.Size() {
+			return lumpError("textures", i, "offset %d out of bounds", offset)
+		}
 
-		tex, err := parseTexture(io.NewSectionReader(r, int64(offset), 0xFFFFFF))
+		tex, err := bsp.parseTexture(io.NewSectionReader(r, int64(offset), r.Size()-int64(offset)))
 		if err != nil {
-			return err
+			return lumpError("textures", i, "%s", err)
 		}
 		tex.ID = i
 		// Textures are referred to by index not by name
 		bsp.Textures[i] = tex
 	}
+	bsp.linkAnimations()
 	return nil
 }
 
-func parseTexture(r *io.SectionReader) (*Texture, error) {
+// Maximum number of frames in an animation
+const maxAnimFrames = 10
+
+// linkAnimations finds the frames of animated textures.
+// Animated textures are named +<frame><name> where frame
+// is 0-9 for the main animation and a-j for the alternate
+// one. Like Quake an animation ends at the first missing
+// frame.
+func (bsp *File) linkAnimations() {
+	type animation struct {
+		main, alternate [maxAnimFrames]*Texture
+	}
+	animations := map[string]*animation{}
+	for _, t := range bsp.Textures {
+		if t == nil || len(t.Name) < 2 || t.Name[0] != '+' {
+			continue
+		}
+		a, ok := animations[t.Name[2:]]
+		if !ok {
+			a = &animation{}
+			animations[t.Name[2:]] = a
+		}
+		switch c := t.Name[1]; {
+		case c >= '0' && c <= '9':
+			a.main[c-'0'] = t
+		case c >= 'a' && c <= 'j':
+			a.alternate[c-'a'] = t
+		case c >= 'A' && c <= 'J':
+			a.alternate[c-'A'] = t
+		}
+	}
+
+	sequence := func(frames []*Texture) []*Texture {
+		for i, t := range frames {
+			if t == nil {
+				frames = frames[:i]
+				break
+			}
+		}
+		if len(frames) == 0 {
+			return nil
+		}
+		return frames
+	}
+	for _, a := range animations {
+		main := sequence(a.main[:])
+		alternate := sequence(a.alternate[:])
+		for _, t := range main {
+			t.AnimFrames = main
+			t.AlternateFrames = alternate
+		}
+		for _, t := range alternate {
+			t.AnimFrames = alternate
+			t.AlternateFrames = main
+		}
+	}
+}
+
+func (bsp *File) parseTexture(r *io.SectionReader) (*Texture, error) {
 	var tex textureData
 	err := binary.Read(r, binary.LittleEndian, &tex)
 	if err != nil {
@@ -51,36 +144,282 @@ func parseTexture(r *io.SectionReader) (*Texture, error) {
 	}
 
 	t := &Texture{
-		Name:   fromCString(tex.Name[:]),
+		Name:   cstring.String(tex.Name[:]),
 		Width:  int(tex.Width),
 		Height: int(tex.Height),
 	}
+	if tex.Width == 0 || tex.Height == 0 || tex.Width&15 != 0 || tex.Height&15 != 0 ||
+		tex.Width > 4096 || tex.Height > 4096 {
+		return nil, fmt.Errorf("invalid size %dx%d", tex.Width, tex.Height)
+	}
+
+	if tex.Offsets[0] == 0 {
+		// Half-Life maps can refer to textures stored in
+		// external wad files, use a placeholder for them.
+		for i := uint(0); i < 4; i++ {
+			t.Pictures[i] = placeholderPicture(t.Width>>i, t.Height>>i)
+		}
+		return t, nil
+	}
+	if int64(t.Width)*int64(t.Height) > r.Size() {
+		return nil, fmt.Errorf("size %dx%d larger than the lump", tex.Width, tex.Height)
+	}
 
 	for i := uint(0); i < 4; i++ {
-		t.Pictures[i] = readPicture(
+		t.Pictures[i], err = readPicture(
 			r,
 			int64(tex.Offsets[i]),
 			t.Width>>i,
 			t.Height>>i,
 		)
+		if err != nil {
+			return nil, fmt.Errorf("mip level %d: %s", i, err)
+		}
+	}
+
+	if bsp.Version == VersionHalfLife {
+		// The texture's palette follows the last mip level
+		offset := int64(tex.Offsets[3]) + int64(t.Width>>3)*int64(t.Height>>3)
+		var count uint16
+		err = binary.Read(io.NewSectionReader(r, offset, 2), binary.LittleEndian, &count)
+		if err != nil {
+			return nil, fmt.Errorf("palette: %s", err)
+		}
+		if count > 256 {
+			return nil, fmt.Errorf("palette size %d too large", count)
+		}
+		t.Palette = make([]byte, 256*3)
+		_, err = r.ReadAt(t.Palette[:int(count)*3], offset+2)
+		if err != nil {
+			return nil, fmt.Errorf("palette: %s", err)
+		}
 	}
 
 	return t, nil
 }
 
+// placeholderPicture returns a checkerboard picture used
+// in place of missing textures.
+func placeholderPicture(width, height int) *Picture {
+	data := make([]byte, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x/8+y/8)%2 == 0 {
+				data[y*width+x] = 15
+			}
+		}
+	}
+	return &Picture{
+		Width:  width,
+		Height: height,
+		Data:   data,
+	}
+}
+
+// RemapPalette converts the pictures of a texture that has
+// its own palette (e.g. from Half-Life maps) to use the
+// passed palette instead, using the closest colour for each
+// index. Fullbright colours (224 and above in Quake's
+// palette) are avoided. Palette is nil afterwards.
+func (t *Texture) RemapPalette(palette []byte) {
+	if t.Palette == nil {
+		return
+	}
+	var mapping [256]byte
+	for i := range mapping {
+		c := t.Palette[i*3 : i*3+3]
+		mapping[i] = nearestColour(palette, c[0], c[1], c[2], false)
+	}
+	for _, pic := range t.Pictures {
+		for i, d := range pic.Data {
+			pic.Data[i] = mapping[d]
+		}
+	}
+	t.Palette = nil
+}
+
+// FirstFullbright is the first colour of Quake's palette
+// that isn't affected by lighting
+const FirstFullbright = 224
+
+// nearestColour returns the index of the colour in the
+// palette closest to the passed colour.
+func nearestColour(palette []byte, r, g, b uint8, fullbright bool) byte {
+	count := len(palette) / 3
+	if !fullbright && count > FirstFullbright {
+		count = FirstFullbright
+	}
+	best := 0
+	bestDist := -1
+	for i := 0; i < count; i++ {
+		dr := int(palette[i*3]) - int(r)
+		dg := int(palette[i*3+1]) - int(g)
+		db := int(palette[i*3+2]) - int(b)
+		dist := dr*dr + dg*dg + db*db
+		if bestDist == -1 || dist < bestDist {
+			best = i
+			bestDist = dist
+		}
+	}
+	return byte(best)
+}
+
+// TextureFromImage creates a texture from the image,
+// converting it to the palette (RGB triples) and generating
+// the smaller mip levels. The image's size must be a
+// multiple of 16. Fullbright colours are only used for
+// pixels that match them exactly so that ordinary colours
+// don't glow in the dark.
+func TextureFromImage(name string, img image.Image, palette []byte) (*Texture, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 || width&15 != 0 || height&15 != 0 || width > 4096 || height > 4096 {
+		return nil, fmt.Errorf("invalid size %dx%d", width, height)
+	}
+	if len(name) >= len(textureData{}.Name) {
+		return nil, fmt.Errorf("name %q too long", name)
+	}
+	if len(palette) < 3 {
+		return nil, errors.New("empty palette")
+	}
+
+	rgb := make([]uint8, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			i := (y*width + x) * 3
+			rgb[i], rgb[i+1], rgb[i+2] = uint8(r>>8), uint8(g>>8), uint8(b>>8)
+		}
+	}
+
+	q := newQuantizer(palette)
+	t := &Texture{
+		Name:   name,
+		Width:  width,
+		Height: height,
+	}
+	for i := uint(0); i < 4; i++ {
+		// Each mip level averages blocks of the full
+		// sized image
+		size := 1 << i
+		pic := &Picture{
+			Width:  width >> i,
+			Height: height >> i,
+		}
+		pic.Data = make([]byte, pic.Width*pic.Height)
+		for y := 0; y < pic.Height; y++ {
+			for x := 0; x < pic.Width; x++ {
+				var sum [3]int
+				for by := 0; by < size; by++ {
+					row := ((y*size+by)*width + x*size) * 3
+					for bx := 0; bx < size*3; bx += 3 {
+						sum[0] += int(rgb[row+bx])
+						sum[1] += int(rgb[row+bx+1])
+						sum[2] += int(rgb[row+bx+2])
+					}
+				}
+				count := size * size
+				pic.Data[y*pic.Width+x] = q.index(
+					uint8(sum[0]/count),
+					uint8(sum[1]/count),
+					uint8(sum[2]/count),
+				)
+			}
+		}
+		t.Pictures[i] = pic
+	}
+	return t, nil
+}
+
+// Texture returns the texture with the name, ignoring case,
+// or nil if the file doesn't have one.
+func (bsp *File) Texture(name string) *Texture {
+	for _, t := range bsp.Textures {
+		if t != nil && strings.EqualFold(t.Name, name) {
+			return t
+		}
+	}
+	return nil
+}
+
+// ReplaceTexture replaces the pictures of the named texture
+// with the image, see TextureFromImage. The texture's size
+// may change. The textures lump is rebuilt from the new
+// pictures when the file is written.
+func (bsp *File) ReplaceTexture(name string, img image.Image, palette []byte) error {
+	t := bsp.Texture(name)
+	if t == nil {
+		return ErrUnknownTexture
+	}
+	nt, err := TextureFromImage(t.Name, img, palette)
+	if err != nil {
+		return err
+	}
+	// The existing texture is updated as the texture info
+	// refers to it
+	t.Width, t.Height = nt.Width, nt.Height
+	t.Pictures = nt.Pictures
+	t.Palette = nil
+	return nil
+}
+
+// quantizer converts colours to the closest colour in a
+// palette, caching the results.
+type quantizer struct {
+	palette    []byte
+	fullbright map[[3]uint8]byte
+	cache      map[[3]uint8]byte
+}
+
+func newQuantizer(palette []byte) *quantizer {
+	q := &quantizer{
+		palette:    palette,
+		fullbright: map[[3]uint8]byte{},
+		cache:      map[[3]uint8]byte{},
+	}
+	for i := FirstFullbright; i < len(palette)/3; i++ {
+		c := [3]uint8{palette[i*3], palette[i*3+1], palette[i*3+2]}
+		if _, ok := q.fullbright[c]; !ok {
+			q.fullbright[c] = byte(i)
+		}
+	}
+	return q
+}
+
+func (q *quantizer) index(r, g, b uint8) byte {
+	c := [3]uint8{r, g, b}
+	if i, ok := q.cache[c]; ok {
+		return i
+	}
+	i := nearestColour(q.palette, r, g, b, false)
+	if p := q.palette[int(i)*3:]; p[0] != r || p[1] != g || p[2] != b {
+		if fb, ok := q.fullbright[c]; ok {
+			i = fb
+		}
+	}
+	q.cache[c] = i
+	return i
+}
+
 type Picture struct {
 	Width, Height int
 	Data          []byte
 }
 
-func readPicture(r *io.SectionReader, offset int64, width, height int) *Picture {
+func readPicture(r *io.SectionReader, offset int64, width, height int) (*Picture, error) {
+	if offset < 0 || offset+int64(width*height) > r.Size() {
+		return nil, fmt.Errorf("offset %d out of bounds", offset)
+	}
 	data := make([]byte, width*height)
-	io.ReadFull(r, data)
+	_, err := r.ReadAt(data, offset)
+	if err != nil {
+		return nil, err
+	}
 	return &Picture{
 		Width:  width,
 		Height: height,
 		Data:   data,
-	}
+	}, nil
 }
 
 type textureData struct {
@@ -13,6 +13,10 @@ type TextureInfo struct {
 	DistT    float32
 	Texture  *Texture
 	Animated bool
+
+	// Original values kept for writing the file
+	textureID uint32
+	flags     uint32
 }
 
 type textureInfoData struct {
@@ -35,14 +39,24 @@ func (bsp *File) parseTextureInfo(r *io.SectionReader, count int) error {
 
 	for i := 0; i < count; i++ {
 		t := textures[i]
-		bsp.textureInfo[i] = &TextureInfo{
-			VectorS:  t.VectorS,
-			DistS:    t.DistS,
-			VectorT:  t.VectorT,
-			DistT:    t.DistT,
-			Texture:  bsp.Textures[t.TextureID],
-			Animated: t.Animated != 0,
+		info := &TextureInfo{
+			VectorS:   t.VectorS,
+			DistS:     t.DistS,
+			VectorT:   t.VectorT,
+			DistT:     t.DistT,
+			Animated:  t.Animated != 0,
+			textureID: t.TextureID,
+			flags:     t.Animated,
+		}
+		// Maps without any textures are allowed, their faces
+		// are left without a texture.
+		if len(bsp.Textures) > 0 {
+			if t.TextureID >= uint32(len(bsp.Textures)) {
+				return lumpError("texinfo", i, "texture %d out of range (%d)", t.TextureID, len(bsp.Textures))
+			}
+			info.Texture = bsp.Textures[t.TextureID]
 		}
+		bsp.textureInfo[i] = info
 	}
 	return nil
 }
@@ -0,0 +1,179 @@
+package bsp
+
+import (
+	"github.com/thinkofdeath/goquake/vmath"
+	"io"
+)
+
+// Contents is the type of the space contained within
+// a leaf.
+type Contents int
+
+// Leaf contents types
+const (
+	ContentsEmpty Contents = -1 - iota
+	ContentsSolid
+	ContentsWater
+	ContentsSlime
+	ContentsLava
+	ContentsSky
+)
+
+// Node is a node in the bsp tree that splits space in
+// two using its plane.
+type Node struct {
+	Plane *Plane
+	// Children contains the front and back children of
+	// the node. Positive values are indices into Nodes
+	// and negative values are leaves, -(index+1) into
+	// Leaves.
+	Children [2]int
+	Bound    BoundingBox
+	Faces    []*Face
+
+	faceID int
+}
+
+// Leaf is a convex area at the edge of the bsp tree.
+type Leaf struct {
+	ID       int
+	Contents Contents
+	Bound    BoundingBox
+	Faces    []*Face
+	// Ambient contains the ambient sound levels for water,
+	// sky, slime and lava
+	Ambient   [4]uint8
+	visOffset int
+
+	faceListID int
+}
+
+type nodeData struct {
+	PlaneID  int32
+	Children [2]int32
+	Bound    BoundingBox
+	FaceID   uint32
+	FaceNum  uint32
+}
+
+type leafData struct {
+	Contents    int32
+	VisOffset   int32
+	Bound       BoundingBox
+	FaceListID  uint32
+	FaceListNum uint32
+	Ambient     [4]uint8
+}
+
+func (bsp *File) parseFaceList(r *io.SectionReader, count int) error {
+	list, err := bsp.readFaceList(r, count)
+	if err != nil {
+		return err
+	}
+
+	bsp.faceList = make([]*Face, count)
+	for i, f := range list {
+		if int(f) >= len(bsp.faces) {
+			return lumpError("facelist", i, "face %d out of range (%d)", f, len(bsp.faces))
+		}
+		bsp.faceList[i] = bsp.faces[f]
+	}
+	return nil
+}
+
+func (bsp *File) parseLeaves(r *io.SectionReader, count int) error {
+	bsp.Leaves = make([]*Leaf, count)
+
+	leaves, err := bsp.readLeaves(r, count)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		l := leaves[i]
+		if err := checkRange("leaves", i, "face list", int(l.FaceListID), int(l.FaceListNum), len(bsp.faceList)); err != nil {
+			return err
+		}
+		bsp.Leaves[i] = &Leaf{
+			ID:         i,
+			Contents:   Contents(l.Contents),
+			Bound:      l.Bound,
+			Faces:      bsp.faceList[l.FaceListID : int(l.FaceListID)+int(l.FaceListNum)],
+			Ambient:    l.Ambient,
+			visOffset:  int(l.VisOffset),
+			faceListID: int(l.FaceListID),
+		}
+	}
+	return nil
+}
+
+func (bsp *File) parseNodes(r *io.SectionReader, count int) error {
+	bsp.Nodes = make([]*Node, count)
+
+	nodes, err := bsp.readNodes(r, count)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		n := nodes[i]
+		if n.PlaneID < 0 || int(n.PlaneID) >= len(bsp.planes) {
+			return lumpError("nodes", i, "plane %d out of range (%d)", n.PlaneID, len(bsp.planes))
+		}
+		if err := checkRange("nodes", i, "faces", int(n.FaceID), int(n.FaceNum), len(bsp.faces)); err != nil {
+			return err
+		}
+		for _, c := range n.Children {
+			if c >= 0 && int(c) >= count {
+				return lumpError("nodes", i, "child node %d out of range (%d)", c, count)
+			}
+			if c < 0 && int(-(c+1)) >= len(bsp.Leaves) {
+				return lumpError("nodes", i, "child leaf %d out of range (%d)", -(c + 1), len(bsp.Leaves))
+			}
+		}
+		bsp.Nodes[i] = &Node{
+			Plane:    bsp.planes[n.PlaneID],
+			Children: [2]int{int(n.Children[0]), int(n.Children[1])},
+			Bound:    n.Bound,
+			Faces:    bsp.faces[n.FaceID : int(n.FaceID)+int(n.FaceNum)],
+			faceID:   int(n.FaceID),
+		}
+	}
+	bsp.makePointHull()
+	return nil
+}
+
+func shortBound(min, max [3]int16) BoundingBox {
+	return BoundingBox{
+		Min: vmath.Vector3{X: float32(min[0]), Y: float32(min[1]), Z: float32(min[2])},
+		Max: vmath.Vector3{X: float32(max[0]), Y: float32(max[1]), Z: float32(max[2])},
+	}
+}
+
+// PointInLeaf returns the leaf of the world model that
+// contains the point.
+func (bsp *File) PointInLeaf(p vmath.Vector3) *Leaf {
+	if len(bsp.Nodes) == 0 {
+		return nil
+	}
+	n := bsp.Models[0].HeadNodes[0]
+	for n >= 0 {
+		node := bsp.Nodes[n]
+		if node.Plane.Distance(p) > 0 {
+			n = node.Children[0]
+		} else {
+			n = node.Children[1]
+		}
+	}
+	return bsp.Leaves[-(n + 1)]
+}
+
+// PointContents returns the contents of the world at the
+// point.
+func (bsp *File) PointContents(p vmath.Vector3) Contents {
+	l := bsp.PointInLeaf(p)
+	if l == nil {
+		return ContentsSolid
+	}
+	return l.Contents
+}
@@ -17,19 +17,22 @@ type Edge struct {
 }
 
 type edgeData struct {
-	Vertex0 uint16
-	Vertex1 uint16
+	Vertex0 uint32
+	Vertex1 uint32
 }
 
 func (bsp *File) parseEdges(r *io.SectionReader, count int) error {
 	bsp.Edges = make([]Edge, count)
 
-	headers := make([]edgeData, count)
-	err := binary.Read(r, binary.LittleEndian, headers)
+	headers, err := bsp.readEdges(r, count)
 	if err != nil {
 		return err
 	}
 	for i := 0; i < count; i++ {
+		h := headers[i]
+		if int(h.Vertex0) >= len(bsp.vertices) || int(h.Vertex1) >= len(bsp.vertices) {
+			return lumpError("edges", i, "vertex %d or %d out of range (%d)", h.Vertex0, h.Vertex1, len(bsp.vertices))
+		}
 		bsp.Edges[i] = Edge{
 			Vertex0: &bsp.vertices[headers[i].Vertex0],
 			Vertex1: &bsp.vertices[headers[i].Vertex1],
@@ -37,3 +40,23 @@ func (bsp *File) parseEdges(r *io.SectionReader, count int) error {
 	}
 	return nil
 }
+
+func (bsp *File) parseLedges(r *io.SectionReader, count int) error {
+	ledges := make([]int32, count)
+	err := binary.Read(r, binary.LittleEndian, ledges)
+	if err != nil {
+		return err
+	}
+	bsp.ledges = make([]int, count)
+	for i, l := range ledges {
+		e := int(l)
+		if e < 0 {
+			e = -e
+		}
+		if e >= len(bsp.Edges) {
+			return lumpError("ledges", i, "edge %d out of range (%d)", l, len(bsp.Edges))
+		}
+		bsp.ledges[i] = int(l)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package bsp
+
+// DecompressVis returns the potentially visible set for
+// the leaf as a bit set. Bit n being set means that leaf
+// n+1 may be visible from the passed leaf (leaf 0 is
+// always solid so isn't included).
+func (bsp *File) DecompressVis(l *Leaf) []byte {
+	row := (bsp.Models[0].VisLeafs + 7) >> 3
+	out := make([]byte, row)
+
+	if l.ID == 0 || l.visOffset < 0 || l.visOffset >= len(bsp.visibility) {
+		// No visibility information, everything is visible
+		for i := range out {
+			out[i] = 0xFF
+		}
+		return out
+	}
+
+	in := bsp.visibility[l.visOffset:]
+	for o, i := 0, 0; o < row && i < len(in); {
+		if in[i] != 0 {
+			out[o] = in[i]
+			o++
+			i++
+			continue
+		}
+		// A zero byte is followed by the number of zero
+		// bytes to output
+		if i+1 >= len(in) {
+			break
+		}
+		o += int(in[i+1])
+		i += 2
+	}
+	return out
+}
+
+// VisibleLeaves returns the leaves that are potentially
+// visible from the passed leaf.
+func (bsp *File) VisibleLeaves(l *Leaf) []*Leaf {
+	vis := bsp.DecompressVis(l)
+	var out []*Leaf
+	for i := 0; i < bsp.Models[0].VisLeafs && i+1 < len(bsp.Leaves); i++ {
+		if vis[i>>3]&(1<<uint(i&7)) != 0 {
+			out = append(out, bsp.Leaves[i+1])
+		}
+	}
+	return out
+}
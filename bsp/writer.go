@@ -0,0 +1,742 @@
+package bsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/thinkofdeath/goquake/internal/cstring"
+	"github.com/thinkofdeath/goquake/vmath"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	numLumps   = 15
+	headerSize = 4 + numLumps*8
+)
+
+// Indices of the lumps in the header
+const (
+	lumpEntities = iota
+	lumpPlanes
+	lumpTextures
+	lumpVertices
+	lumpVisibility
+	lumpNodes
+	lumpTextureInfo
+	lumpFaces
+	lumpLightMaps
+	lumpClipNodes
+	lumpLeaves
+	lumpFaceList
+	lumpEdges
+	lumpLedges
+	lumpModels
+)
+
+var lumpNames = [numLumps]string{
+	lumpEntities:    "entities",
+	lumpPlanes:      "planes",
+	lumpTextures:    "textures",
+	lumpVertices:    "vertices",
+	lumpVisibility:  "visibility",
+	lumpNodes:       "nodes",
+	lumpTextureInfo: "texinfo",
+	lumpFaces:       "faces",
+	lumpLightMaps:   "lightmaps",
+	lumpClipNodes:   "clipnodes",
+	lumpLeaves:      "leaves",
+	lumpFaceList:    "facelist",
+	lumpEdges:       "edges",
+	lumpLedges:      "ledges",
+	lumpModels:      "models",
+}
+
+// The order qbsp stores the lumps in, used when the
+// original layout isn't known.
+var canonicalOrder = [numLumps]int{
+	lumpPlanes, lumpLeaves, lumpVertices, lumpNodes, lumpTextureInfo,
+	lumpFaces, lumpClipNodes, lumpFaceList, lumpLedges, lumpEdges,
+	lumpModels, lumpLightMaps, lumpVisibility, lumpEntities, lumpTextures,
+}
+
+func (h *bspHeader) entries() [numLumps]*bspEntry {
+	return [numLumps]*bspEntry{
+		&h.Entities, &h.Planes, &h.WallTextures, &h.Vertices, &h.VisibilityList,
+		&h.Nodes, &h.TextureInfo, &h.Faces, &h.LightMaps, &h.ClipNodes,
+		&h.Leaves, &h.FaceList, &h.Edges, &h.Ledges, &h.Models,
+	}
+}
+
+// fileLayout is the placement of the lumps in the
+// original file.
+type fileLayout struct {
+	// Lumps in the order they are stored in the file
+	order [numLumps]int
+	// Bytes before each lump that aren't part of any lump,
+	// e.g. padding
+	gaps [numLumps][]byte
+	// Bytes after the last lump
+	tail []byte
+	// Offsets of empty lumps that don't follow on from
+	// the lump before them (e.g. an empty lump at offset
+	// 0), -1 for the rest. These are kept as they were
+	// rather than moved.
+	emptyOffsets [numLumps]int32
+}
+
+// readLayout records the layout of a version 29 file so
+// that it can be written back exactly. Other versions are
+// written with the layout used by qbsp.
+func readLayout(r *io.SectionReader, h *bspHeader) (*fileLayout, error) {
+	if h.Version != VersionQuake {
+		return nil, nil
+	}
+	entries := h.entries()
+	l := &fileLayout{}
+	for i := range l.order {
+		l.order[i] = i
+	}
+	sort.SliceStable(l.order[:], func(a, b int) bool {
+		return entries[l.order[a]].Offset < entries[l.order[b]].Offset
+	})
+
+	end := int64(headerSize)
+	for _, i := range l.order {
+		e := entries[i]
+		l.emptyOffsets[i] = -1
+		if e.Offset < 0 || e.Size < 0 || int64(e.Offset)+int64(e.Size) > r.Size() {
+			return nil, lumpError(lumpNames[i], -1, "offset %d, size %d out of bounds", e.Offset, e.Size)
+		}
+		if e.Size == 0 && int64(e.Offset) < end {
+			l.emptyOffsets[i] = e.Offset
+			continue
+		}
+		if int64(e.Offset) > end {
+			l.gaps[i] = make([]byte, int64(e.Offset)-end)
+			if _, err := r.ReadAt(l.gaps[i], end); err != nil {
+				return nil, err
+			}
+		}
+		if lumpEnd := int64(e.Offset) + int64(e.Size); lumpEnd > end {
+			end = lumpEnd
+		}
+	}
+	if r.Size() > end {
+		l.tail = make([]byte, r.Size()-end)
+		if _, err := r.ReadAt(l.tail, end); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Write writes the file as a version 29 (Quake) BSP file.
+// Lumps that haven't been modified are written unchanged
+// so an unmodified version 29 file is written back exactly
+// as it was read. Maps that don't fit within the limits
+// of version 29 return a LumpError. Textures with their
+// own palette must be converted with RemapPalette first.
+func (bsp *File) Write(w io.Writer) error {
+	var lumps [numLumps][]byte
+	enc := &encoder{bsp: bsp}
+	var err error
+	if lumps[lumpEntities], err = bsp.encodeEntities(); err != nil {
+		return err
+	}
+	enc.indexTables()
+	encoders := []struct {
+		lump   int
+		encode func() ([]byte, error)
+	}{
+		// Encoded before the planes, texinfo and textures
+		// as they can add to them
+		{lumpFaces, enc.facesLump},
+		{lumpClipNodes, enc.clipNodesLump},
+		{lumpNodes, enc.nodesLump},
+		{lumpTextureInfo, enc.textureInfoLump},
+		{lumpPlanes, enc.planesLump},
+		{lumpTextures, enc.texturesLump},
+		{lumpVertices, enc.verticesLump},
+		{lumpLeaves, enc.leavesLump},
+		{lumpFaceList, enc.faceListLump},
+		{lumpEdges, enc.edgesLump},
+		{lumpLedges, enc.ledgesLump},
+		{lumpModels, enc.modelsLump},
+	}
+	for _, e := range encoders {
+		if lumps[e.lump], err = e.encode(); err != nil {
+			return err
+		}
+	}
+	lumps[lumpVisibility] = bsp.visibility
+	lumps[lumpLightMaps] = bsp.LightMaps
+
+	var header bspHeader
+	header.Version = VersionQuake
+	entries := header.entries()
+	order := canonicalOrder
+	var gaps [numLumps][]byte
+	var tail []byte
+	if l := bsp.layout; l != nil {
+		order, gaps, tail = l.order, l.gaps, l.tail
+	} else {
+		// Align each lump to 4 bytes like qbsp
+		offset := headerSize
+		for _, i := range order {
+			gaps[i] = make([]byte, (4-offset%4)%4)
+			offset += len(gaps[i]) + len(lumps[i])
+		}
+	}
+
+	offset := headerSize
+	for _, i := range order {
+		if l := bsp.layout; l != nil && len(lumps[i]) == 0 && l.emptyOffsets[i] >= 0 {
+			*entries[i] = bspEntry{Offset: l.emptyOffsets[i]}
+			continue
+		}
+		offset += len(gaps[i])
+		if offset+len(lumps[i]) > math.MaxInt32 {
+			return lumpError(lumpNames[i], -1, "file too large")
+		}
+		*entries[i] = bspEntry{Offset: int32(offset), Size: int32(len(lumps[i]))}
+		offset += len(lumps[i])
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	for _, i := range order {
+		if _, err := w.Write(gaps[i]); err != nil {
+			return err
+		}
+		if _, err := w.Write(lumps[i]); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(tail)
+	return err
+}
+
+// encodeEntities returns the original entities lump if
+// the entities haven't been changed.
+func (bsp *File) encodeEntities() ([]byte, error) {
+	if bsp.rawEntities != nil {
+		orig, err := ParseEntities(cstring.String(bsp.rawEntities))
+		if err == nil && reflect.DeepEqual(orig, bsp.Entities) {
+			return bsp.rawEntities, nil
+		}
+	}
+	var buf bytes.Buffer
+	for i, e := range bsp.Entities {
+		buf.WriteString("{\n")
+		for j, key := range e.Keys {
+			value := e.Values[j]
+			if strings.Contains(key, "\"") || strings.Contains(value, "\"") {
+				return nil, lumpError("entities", i, "key %q contains a quote", key)
+			}
+			fmt.Fprintf(&buf, "\"%s\" \"%s\"\n", key, value)
+		}
+		buf.WriteString("}\n")
+	}
+	buf.WriteByte(0)
+	return buf.Bytes(), nil
+}
+
+// texturesModified returns whether the textures differ
+// from the original textures lump.
+func (bsp *File) texturesModified() bool {
+	if bsp.Version != VersionQuake || bsp.rawTextures == nil {
+		return true
+	}
+	orig := &File{Version: bsp.Version}
+	r := io.NewSectionReader(bytes.NewReader(bsp.rawTextures), 0, int64(len(bsp.rawTextures)))
+	if orig.parseTextures(r, len(bsp.rawTextures)) != nil || len(orig.Textures) != len(bsp.Textures) {
+		return true
+	}
+	for i, t := range bsp.Textures {
+		o := orig.Textures[i]
+		if t == nil || o == nil {
+			if t != o {
+				return true
+			}
+			continue
+		}
+		if t.Name != o.Name || t.Width != o.Width || t.Height != o.Height || t.Palette != nil {
+			return true
+		}
+		for j, p := range t.Pictures {
+			op := o.Pictures[j]
+			if p == nil || p.Width != op.Width || p.Height != op.Height || !bytes.Equal(p.Data, op.Data) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encoder converts the parsed file back into the lumps of
+// a version 29 file.
+type encoder struct {
+	bsp *File
+
+	planes      []*Plane
+	planeIDs    map[*Plane]int
+	textures    []*Texture
+	textureIDs  map[*Texture]int
+	textureInfo []*TextureInfo
+	texInfoIDs  map[*TextureInfo]int
+	faceIDs     map[*Face]int
+	vertexIDs   map[*vmath.Vector3]int
+}
+
+func (e *encoder) indexTables() {
+	bsp := e.bsp
+	e.planes = append([]*Plane(nil), bsp.planes...)
+	e.planeIDs = make(map[*Plane]int, len(bsp.planes))
+	for i, p := range bsp.planes {
+		e.planeIDs[p] = i
+	}
+	e.textures = append([]*Texture(nil), bsp.Textures...)
+	e.textureIDs = make(map[*Texture]int, len(bsp.Textures))
+	for i, t := range bsp.Textures {
+		if t != nil {
+			e.textureIDs[t] = i
+		}
+	}
+	e.textureInfo = append([]*TextureInfo(nil), bsp.textureInfo...)
+	e.texInfoIDs = make(map[*TextureInfo]int, len(bsp.textureInfo))
+	for i, t := range bsp.textureInfo {
+		e.texInfoIDs[t] = i
+	}
+	e.faceIDs = make(map[*Face]int, len(bsp.faces))
+	for i, f := range bsp.faces {
+		e.faceIDs[f] = i
+	}
+	e.vertexIDs = make(map[*vmath.Vector3]int, len(bsp.vertices))
+	for i := range bsp.vertices {
+		e.vertexIDs[&bsp.vertices[i]] = i
+	}
+}
+
+// planeID returns the index of the plane, adding it if it
+// isn't in the file.
+func (e *encoder) planeID(p *Plane) int {
+	id, ok := e.planeIDs[p]
+	if !ok {
+		id = len(e.planes)
+		e.planes = append(e.planes, p)
+		e.planeIDs[p] = id
+	}
+	return id
+}
+
+func (e *encoder) textureID(t *Texture) int {
+	id, ok := e.textureIDs[t]
+	if !ok {
+		id = len(e.textures)
+		e.textures = append(e.textures, t)
+		e.textureIDs[t] = id
+	}
+	return id
+}
+
+func (e *encoder) texInfoID(t *TextureInfo) int {
+	id, ok := e.texInfoIDs[t]
+	if !ok {
+		id = len(e.textureInfo)
+		e.textureInfo = append(e.textureInfo, t)
+		e.texInfoIDs[t] = id
+	}
+	return id
+}
+
+// checkLimit returns an error if the value doesn't fit in
+// the field of a version 29 file.
+func checkLimit(lump string, index int, what string, v, min, max int) error {
+	if v < min || v > max {
+		return lumpError(lump, index, "%s %d doesn't fit in a version 29 file", what, v)
+	}
+	return nil
+}
+
+func checkUint16(lump string, index int, what string, v int) error {
+	return checkLimit(lump, index, what, v, 0, math.MaxUint16)
+}
+
+func checkInt16(lump string, index int, what string, v int) error {
+	return checkLimit(lump, index, what, v, math.MinInt16, math.MaxInt16)
+}
+
+// shortBounds converts the bound back into the 16 bit
+// bounds used by version 29.
+func shortBounds(lump string, index int, b BoundingBox) (min, max [3]int16, err error) {
+	for i := 0; i < 3; i++ {
+		lo, hi := math.Floor(float64(b.Min.Index(i))), math.Ceil(float64(b.Max.Index(i)))
+		if err = checkInt16(lump, index, "bound", int(lo)); err != nil {
+			return
+		}
+		if err = checkInt16(lump, index, "bound", int(hi)); err != nil {
+			return
+		}
+		min[i], max[i] = int16(lo), int16(hi)
+	}
+	return
+}
+
+// findRange returns the index of a range of n elements
+// within a list of total elements, equal reports whether
+// element i of the list is element j of the range. The
+// hint is the index the range was originally read from
+// and is tried first.
+func findRange(total, n, hint int, equal func(i, j int) bool) (int, bool) {
+	if n == 0 {
+		if hint < 0 || hint > total {
+			return 0, true
+		}
+		return hint, true
+	}
+	matches := func(start int) bool {
+		for j := 0; j < n; j++ {
+			if !equal(start+j, j) {
+				return false
+			}
+		}
+		return true
+	}
+	if hint >= 0 && hint+n <= total && matches(hint) {
+		return hint, true
+	}
+	for i := 0; i+n <= total; i++ {
+		if matches(i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func faceRange(all, sub []*Face, hint int) (int, bool) {
+	return findRange(len(all), len(sub), hint, func(i, j int) bool { return all[i] == sub[j] })
+}
+
+func ledgeRange(all, sub []int, hint int) (int, bool) {
+	return findRange(len(all), len(sub), hint, func(i, j int) bool { return all[i] == sub[j] })
+}
+
+func encode(data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := binary.Write(&buf, binary.LittleEndian, data)
+	return buf.Bytes(), err
+}
+
+func (e *encoder) facesLump() ([]byte, error) {
+	bsp := e.bsp
+	type face struct {
+		PlaneID   uint16
+		Side      uint16
+		LedgeID   int32
+		LedgeNum  uint16
+		TexInfoID uint16
+		Styles    [4]uint8
+		LightMap  int32
+	}
+	faces := make([]face, len(bsp.faces))
+	for i, f := range bsp.faces {
+		planeID := e.planeID(f.Plane)
+		texInfoID := e.texInfoID(f.TextureInfo)
+		ledgeID, ok := ledgeRange(bsp.ledges, f.Ledges, f.ledgeID)
+		if !ok {
+			return nil, lumpError("faces", i, "edges aren't in the edge list")
+		}
+		if err := checkUint16("faces", i, "plane", planeID); err != nil {
+			return nil, err
+		}
+		if err := checkUint16("faces", i, "texinfo", texInfoID); err != nil {
+			return nil, err
+		}
+		if err := checkUint16("faces", i, "edge count", len(f.Ledges)); err != nil {
+			return nil, err
+		}
+		side := f.side
+		if (side == 0) != f.Front {
+			side = 1
+			if f.Front {
+				side = 0
+			}
+		}
+		lightMap := f.LightMap
+		if int(lightMap) >= len(bsp.LightMaps) {
+			lightMap = -1
+		}
+		faces[i] = face{
+			PlaneID:   uint16(planeID),
+			Side:      uint16(side),
+			LedgeID:   int32(ledgeID),
+			LedgeNum:  uint16(len(f.Ledges)),
+			TexInfoID: uint16(texInfoID),
+			Styles:    f.Styles,
+			LightMap:  lightMap,
+		}
+	}
+	return encode(faces)
+}
+
+func (e *encoder) clipNodesLump() ([]byte, error) {
+	hull := e.bsp.Hulls[HullPlayer]
+	if hull == nil {
+		return nil, nil
+	}
+	type clipNode struct {
+		PlaneID  int32
+		Children [2]int16
+	}
+	nodes := make([]clipNode, len(hull.ClipNodes))
+	for i, n := range hull.ClipNodes {
+		nodes[i].PlaneID = int32(e.planeID(n.Plane))
+		for j, c := range n.Children {
+			if err := checkInt16("clipnodes", i, "child", c); err != nil {
+				return nil, err
+			}
+			nodes[i].Children[j] = int16(c)
+		}
+	}
+	return encode(nodes)
+}
+
+func (e *encoder) nodesLump() ([]byte, error) {
+	bsp := e.bsp
+	type node struct {
+		PlaneID  int32
+		Children [2]int16
+		Min, Max [3]int16
+		FaceID   uint16
+		FaceNum  uint16
+	}
+	nodes := make([]node, len(bsp.Nodes))
+	for i, n := range bsp.Nodes {
+		faceID, ok := faceRange(bsp.faces, n.Faces, n.faceID)
+		if !ok {
+			return nil, lumpError("nodes", i, "faces aren't in the face list")
+		}
+		if err := checkUint16("nodes", i, "face", faceID); err != nil {
+			return nil, err
+		}
+		if err := checkUint16("nodes", i, "face count", len(n.Faces)); err != nil {
+			return nil, err
+		}
+		min, max, err := shortBounds("nodes", i, n.Bound)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = node{
+			PlaneID: int32(e.planeID(n.Plane)),
+			Min:     min,
+			Max:     max,
+			FaceID:  uint16(faceID),
+			FaceNum: uint16(len(n.Faces)),
+		}
+		for j, c := range n.Children {
+			if err := checkInt16("nodes", i, "child", c); err != nil {
+				return nil, err
+			}
+			nodes[i].Children[j] = int16(c)
+		}
+	}
+	return encode(nodes)
+}
+
+func (e *encoder) textureInfoLump() ([]byte, error) {
+	infos := make([]textureInfoData, len(e.textureInfo))
+	for i, t := range e.textureInfo {
+		textureID := t.textureID
+		if t.Texture != nil {
+			textureID = uint32(e.textureID(t.Texture))
+		}
+		flags := t.flags &^ 1
+		if t.Animated {
+			flags |= 1
+		}
+		infos[i] = textureInfoData{
+			VectorS:   t.VectorS,
+			DistS:     t.DistS,
+			VectorT:   t.VectorT,
+			DistT:     t.DistT,
+			TextureID: textureID,
+			Animated:  flags,
+		}
+	}
+	return encode(infos)
+}
+
+func (e *encoder) planesLump() ([]byte, error) {
+	planes := make([]planeData, len(e.planes))
+	for i, p := range e.planes {
+		planes[i] = planeData{
+			Normal: p.Normal,
+			Dist:   p.Dist,
+			Type:   int32(p.Type),
+		}
+	}
+	return encode(planes)
+}
+
+func (e *encoder) texturesLump() ([]byte, error) {
+	bsp := e.bsp
+	if len(e.textures) == len(bsp.Textures) && !bsp.texturesModified() {
+		return bsp.rawTextures, nil
+	}
+	if len(e.textures) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	offsets := make([]int32, len(e.textures))
+	// Leave space for the count and offsets
+	buf.Write(make([]byte, 4+4*len(offsets)))
+	for i, t := range e.textures {
+		if t == nil {
+			offsets[i] = -1
+			continue
+		}
+		offsets[i] = int32(buf.Len())
+		tex := textureData{
+			Width:  uint32(t.Width),
+			Height: uint32(t.Height),
+		}
+		if t.Palette != nil {
+			return nil, lumpError("textures", i, "texture has its own palette, see RemapPalette")
+		}
+		if len(t.Name) >= len(tex.Name) {
+			return nil, lumpError("textures", i, "name %q too long", t.Name)
+		}
+		copy(tex.Name[:], t.Name)
+		offset := uint32(binary.Size(tex))
+		for j, p := range t.Pictures {
+			if p == nil || p.Width != t.Width>>uint(j) || p.Height != t.Height>>uint(j) || len(p.Data) != p.Width*p.Height {
+				return nil, lumpError("textures", i, "invalid mip level %d", j)
+			}
+			tex.Offsets[j] = offset
+			offset += uint32(len(p.Data))
+		}
+		if err := binary.Write(&buf, binary.LittleEndian, &tex); err != nil {
+			return nil, err
+		}
+		for _, p := range t.Pictures {
+			buf.Write(p.Data)
+		}
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data, uint32(len(offsets)))
+	for i, o := range offsets {
+		binary.LittleEndian.PutUint32(data[4+i*4:], uint32(o))
+	}
+	return data, nil
+}
+
+func (e *encoder) verticesLump() ([]byte, error) {
+	return encode(e.bsp.vertices)
+}
+
+func (e *encoder) leavesLump() ([]byte, error) {
+	bsp := e.bsp
+	type leaf struct {
+		Contents    int32
+		VisOffset   int32
+		Min, Max    [3]int16
+		FaceListID  uint16
+		FaceListNum uint16
+		Ambient     [4]uint8
+	}
+	leaves := make([]leaf, len(bsp.Leaves))
+	for i, l := range bsp.Leaves {
+		faceListID, ok := faceRange(bsp.faceList, l.Faces, l.faceListID)
+		if !ok {
+			return nil, lumpError("leaves", i, "faces aren't in the face list")
+		}
+		if err := checkUint16("leaves", i, "face list", faceListID); err != nil {
+			return nil, err
+		}
+		if err := checkUint16("leaves", i, "face count", len(l.Faces)); err != nil {
+			return nil, err
+		}
+		min, max, err := shortBounds("leaves", i, l.Bound)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf{
+			Contents:    int32(l.Contents),
+			VisOffset:   int32(l.visOffset),
+			Min:         min,
+			Max:         max,
+			FaceListID:  uint16(faceListID),
+			FaceListNum: uint16(len(l.Faces)),
+			Ambient:     l.Ambient,
+		}
+	}
+	return encode(leaves)
+}
+
+func (e *encoder) faceListLump() ([]byte, error) {
+	list := make([]uint16, len(e.bsp.faceList))
+	for i, f := range e.bsp.faceList {
+		id, ok := e.faceIDs[f]
+		if !ok {
+			return nil, lumpError("facelist", i, "face isn't in the file")
+		}
+		if err := checkUint16("facelist", i, "face", id); err != nil {
+			return nil, err
+		}
+		list[i] = uint16(id)
+	}
+	return encode(list)
+}
+
+func (e *encoder) edgesLump() ([]byte, error) {
+	edges := make([][2]uint16, len(e.bsp.Edges))
+	for i, edge := range e.bsp.Edges {
+		for j, v := range []*vmath.Vector3{edge.Vertex0, edge.Vertex1} {
+			id, ok := e.vertexIDs[v]
+			if !ok {
+				return nil, lumpError("edges", i, "vertex isn't in the file")
+			}
+			if err := checkUint16("edges", i, "vertex", id); err != nil {
+				return nil, err
+			}
+			edges[i][j] = uint16(id)
+		}
+	}
+	return encode(edges)
+}
+
+func (e *encoder) ledgesLump() ([]byte, error) {
+	ledges := make([]int32, len(e.bsp.ledges))
+	for i, l := range e.bsp.ledges {
+		ledges[i] = int32(l)
+	}
+	return encode(ledges)
+}
+
+func (e *encoder) modelsLump() ([]byte, error) {
+	bsp := e.bsp
+	models := make([]modelData, len(bsp.Models))
+	for i, m := range bsp.Models {
+		faceID, ok := faceRange(bsp.faces, m.Faces, m.faceID)
+		if !ok {
+			return nil, lumpError("models", i, "faces aren't in the face list")
+		}
+		models[i] = modelData{
+			Bound:       m.Bound,
+			Origin:      m.Origin,
+			NumberLeafs: int32(m.VisLeafs),
+			FaceID:      int32(faceID),
+			FaceNum:     int32(len(m.Faces)),
+		}
+		for j, n := range m.HeadNodes {
+			models[i].NodeID[j] = int32(n)
+		}
+	}
+	return encode(models)
+}
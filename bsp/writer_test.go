@@ -0,0 +1,201 @@
+package bsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/thinkofdeath/goquake/vmath"
+	"io"
+	"testing"
+)
+
+// testLumps returns the lumps of a small version 29 map
+// with a single lit triangle in one leaf.
+func testLumps() [numLumps][]byte {
+	var lumps [numLumps]bytes.Buffer
+	w := func(lump int, v ...interface{}) {
+		for _, x := range v {
+			binary.Write(&lumps[lump], binary.LittleEndian, x)
+		}
+	}
+
+	lumps[lumpEntities].WriteString("{\n\"classname\" \"worldspawn\"\n}\n\x00")
+	w(lumpPlanes, vmath.Vector3{Z: 1}, float32(0), int32(2))
+
+	var name [16]byte
+	copy(name[:], "wall")
+	offset := uint32(40)
+	w(lumpTextures, int32(1), int32(8))
+	w(lumpTextures, name, uint32(16), uint32(16), [4]uint32{offset, offset + 256, offset + 256 + 64, offset + 256 + 64 + 16})
+	pixels := make([]byte, 256+64+16+4)
+	for i := range pixels {
+		pixels[i] = byte(i)
+	}
+	lumps[lumpTextures].Write(pixels)
+
+	w(lumpVertices, vmath.Vector3{}, vmath.Vector3{X: 64}, vmath.Vector3{Y: 64})
+	w(lumpTextureInfo, vmath.Vector3{X: 1}, float32(0), vmath.Vector3{Y: 1}, float32(0), uint32(0), uint32(0))
+	// A 5x5 light map
+	lumps[lumpLightMaps].Write(make([]byte, 25))
+	for _, e := range [][2]uint16{{0, 1}, {1, 2}, {2, 0}} {
+		w(lumpEdges, e)
+	}
+	w(lumpLedges, []int32{0, 1, 2})
+	w(lumpFaces, uint16(0), uint16(0), int32(0), uint16(3), uint16(0), [4]uint8{0, NoStyle, NoStyle, NoStyle}, int32(0))
+	w(lumpFaceList, uint16(0))
+	w(lumpLeaves, int32(ContentsSolid), int32(-1), [6]int16{-64, -64, -64, 64, 64, 64}, uint16(0), uint16(0), [4]uint8{})
+	w(lumpLeaves, int32(ContentsEmpty), int32(-1), [6]int16{-64, -64, -64, 64, 64, 64}, uint16(0), uint16(1), [4]uint8{})
+	w(lumpNodes, int32(0), [2]int16{-2, -1}, [6]int16{-64, -64, -64, 64, 64, 64}, uint16(0), uint16(1))
+	w(lumpClipNodes, int32(0), [2]int16{-1, -2})
+	w(lumpModels, [9]float32{}, [4]int32{}, int32(1), int32(0), int32(1))
+
+	var out [numLumps][]byte
+	for i := range lumps {
+		out[i] = lumps[i].Bytes()
+	}
+	return out
+}
+
+// buildTestBSP lays the lumps out one after another in
+// header order.
+func buildTestBSP(lumps [numLumps][]byte) []byte {
+	var header bspHeader
+	header.Version = VersionQuake
+	entries := header.entries()
+	offset := headerSize
+	for i, l := range lumps {
+		*entries[i] = bspEntry{Offset: int32(offset), Size: int32(len(l))}
+		offset += len(l)
+	}
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, &header)
+	for _, l := range lumps {
+		out.Write(l)
+	}
+	return out.Bytes()
+}
+
+// setEntry changes the header entry of the lump
+func setEntry(data []byte, lump int, offset, size int32) {
+	binary.LittleEndian.PutUint32(data[4+lump*8:], uint32(offset))
+	binary.LittleEndian.PutUint32(data[4+lump*8+4:], uint32(size))
+}
+
+func parseTestBSP(t *testing.T, data []byte) *File {
+	b, err := ParseBSPFile(io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func writeTestBSP(t *testing.T, b *File) []byte {
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriteUnmodified(t *testing.T) {
+	tests := []struct {
+		name string
+		data func() []byte
+	}{
+		{"sequential", func() []byte {
+			return buildTestBSP(testLumps())
+		}},
+		{"trailing bytes", func() []byte {
+			return append(buildTestBSP(testLumps()), 1, 2, 3)
+		}},
+		{"gap and reordered", func() []byte {
+			// Move the textures to the end after some padding
+			lumps := testLumps()
+			textures := lumps[lumpTextures]
+			lumps[lumpTextures] = nil
+			data := buildTestBSP(lumps)
+			offset := len(data) + 5
+			data = append(data, 9, 9, 9, 9, 9)
+			data = append(data, textures...)
+			setEntry(data, lumpTextures, int32(offset), int32(len(textures)))
+			return data
+		}},
+		{"empty lump at offset 0", func() []byte {
+			data := buildTestBSP(testLumps())
+			setEntry(data, lumpVisibility, 0, 0)
+			return data
+		}},
+		{"empty lump inside another lump", func() []byte {
+			data := buildTestBSP(testLumps())
+			planes := binary.LittleEndian.Uint32(data[4+lumpPlanes*8:])
+			setEntry(data, lumpVisibility, int32(planes)+4, 0)
+			return data
+		}},
+	}
+	for _, test := range tests {
+		data := test.data()
+		out := writeTestBSP(t, parseTestBSP(t, data))
+		if !bytes.Equal(out, data) {
+			t.Errorf("%s: written file differs from the original", test.name)
+		}
+	}
+}
+
+func TestParsePartialElement(t *testing.T) {
+	// Lumps with bytes left over after the last element
+	// are rejected rather than written back differently
+	lumps := testLumps()
+	lumps[lumpPlanes] = append(lumps[lumpPlanes], 0, 0, 0)
+	data := buildTestBSP(lumps)
+	_, err := ParseBSPFile(io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	if le, ok := err.(*LumpError); !ok || le.Lump != "planes" {
+		t.Fatalf("expected a planes LumpError, got %v", err)
+	}
+}
+
+func TestParseVisLeafs(t *testing.T) {
+	for _, n := range []int32{-1, 2} {
+		lumps := testLumps()
+		// The model's leaf count follows its bounds, origin
+		// and head nodes
+		binary.LittleEndian.PutUint32(lumps[lumpModels][9*4+4*4:], uint32(n))
+		data := buildTestBSP(lumps)
+		_, err := ParseBSPFile(io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+		if le, ok := err.(*LumpError); !ok || le.Lump != "models" {
+			t.Errorf("%d leaves: expected a models LumpError, got %v", n, err)
+		}
+	}
+}
+
+func TestWriteModifiedEntities(t *testing.T) {
+	data := buildTestBSP(testLumps())
+	b := parseTestBSP(t, data)
+	b.Entities[0].Set("message", "hello")
+	out := writeTestBSP(t, b)
+
+	b2 := parseTestBSP(t, out)
+	if len(b2.Entities) != 1 || b2.Entities[0].ClassName() != "worldspawn" || b2.Entities[0].Value("message") != "hello" {
+		t.Fatalf("unexpected entities %+v", b2.Entities)
+	}
+	if !bytes.Equal(b2.rawTextures, b.rawTextures) {
+		t.Error("textures changed")
+	}
+	if again := writeTestBSP(t, b2); !bytes.Equal(again, out) {
+		t.Error("writing the modified file again changed it")
+	}
+}
+
+func TestWriteModifiedTexture(t *testing.T) {
+	data := buildTestBSP(testLumps())
+	b := parseTestBSP(t, data)
+	b.Textures[0].Pictures[0].Data[0] = 200
+	out := writeTestBSP(t, b)
+
+	b2 := parseTestBSP(t, out)
+	tex := b2.Textures[0]
+	if tex.Name != "wall" || tex.Width != 16 || tex.Pictures[0].Data[0] != 200 || tex.Pictures[0].Data[1] != 1 {
+		t.Fatalf("unexpected texture %+v", tex)
+	}
+	if again := writeTestBSP(t, b2); !bytes.Equal(again, out) {
+		t.Error("writing the modified file again changed it")
+	}
+}
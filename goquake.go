@@ -1,12 +1,20 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/go-gl/glfw/v3.0/glfw"
 	"github.com/thinkofdeath/goquake/pak"
 	"github.com/thinkofdeath/goquake/render"
+	"image"
+	"image/png"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 )
 
@@ -16,9 +24,24 @@ func init() {
 
 var (
 	lockMouse = false
+
+	// Movement keys that are currently held
+	keyForward, keyBack, keyLeft, keyRight bool
+
+	// Levels that can be switched to
+	levels []string
+
+	baseDir = flag.String("basedir", ".", "directory containing the game directories")
+	game    = flag.String("game", "", "mod directory to use on top of id1")
+
+	waterAlpha = flag.Float64("wateralpha", 1, "opacity of water, slime and lava between 0 and 1")
+
+	textureDir = flag.String("textures", "", "directory of png images to replace the level's textures with when T is pressed")
 )
 
 func main() {
+	flag.Parse()
+
 	if !glfw.Init() {
 		panic("glfw error")
 	}
@@ -39,20 +62,22 @@ func main() {
 	glfw.SwapInterval(1)
 
 	start := time.Now()
-	p, err := pak.FromFile("id1/PAK0.PAK")
+	p, err := pak.OpenGame(*baseDir, *game)
 	if err != nil {
 		panic(err)
 	}
+	defer p.Close()
 
-	// Check for the full game
-	p2, err := pak.FromFile("id1/PAK1.PAK")
-	if err == nil {
-		p = pak.Join(p, p2)
+	maps, err := pak.Glob(p, "maps/*.bsp")
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range maps {
+		levels = append(levels, strings.TrimSuffix(path.Base(m.Name), ".bsp"))
 	}
-
-	defer p.Close()
 
 	render.Init(p)
+	render.SetWaterAlpha(float32(*waterAlpha))
 
 	fmt.Println(time.Now().Sub(start))
 
@@ -71,73 +96,81 @@ func main() {
 }
 
 func onKey(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
-	if key == glfw.KeyW {
-		switch action {
-		case glfw.Release:
-			render.StopMove()
-		case glfw.Press:
-			render.MoveForward()
+	if action != glfw.Repeat {
+		held := action == glfw.Press
+		switch key {
+		case glfw.KeyW:
+			keyForward = held
+		case glfw.KeyS:
+			keyBack = held
+		case glfw.KeyA:
+			keyLeft = held
+		case glfw.KeyD:
+			keyRight = held
+		case glfw.KeySpace:
+			render.SetJump(held)
+		}
+		render.SetMovement(axis(keyForward, keyBack), axis(keyRight, keyLeft))
+	}
+
+	if key == glfw.KeyN && action == glfw.Release {
+		render.ToggleNoClip()
+	} else if key == glfw.KeyT && action == glfw.Release && *textureDir != "" {
+		if err := replaceTextures(*textureDir); err != nil {
+			fmt.Println(err)
 		}
 	} else if key == glfw.KeyEscape {
 		lockMouse = false
 		w.SetInputMode(glfw.Cursor, glfw.CursorNormal)
 	} else if key == glfw.Key1 && action == glfw.Release {
-		levels := []string{
-			"b_bh10",
-			"b_bh100",
-			"b_bh25",
-			"b_shell1",
-			"b_shell0",
-			"b_nail1",
-			"b_nail0",
-			"b_rock1",
-			"b_rock0",
-			"b_batt1",
-			"b_batt0",
-			"b_explob",
-			"start",
-			"e1m1",
-			"e1m2",
-			"e1m3",
-			"e1m4",
-			"e1m5",
-			"e1m6",
-			"e1m7",
-			"e1m8",
-			"b_exbox2",
-			"e2m1",
-			"e2m2",
-			"e2m3",
-			"e2m4",
-			"e2m5",
-			"e2m6",
-			"e2m7",
-			"e3m1",
-			"e3m2",
-			"e3m3",
-			"e3m4",
-			"e3m5",
-			"e3m6",
-			"e3m7",
-			"e4m1",
-			"e4m2",
-			"e4m3",
-			"e4m4",
-			"e4m5",
-			"e4m6",
-			"e4m7",
-			"e4m8",
-			"end",
-			"dm1",
-			"dm2",
-			"dm3",
-			"dm4",
-			"dm5",
-			"dm6",
+		if len(levels) > 0 {
+			if err := render.SetLevel(levels[rand.Intn(len(levels))]); err != nil {
+				fmt.Println(err)
+			}
 		}
+	}
+}
 
-		render.SetLevel(levels[rand.Intn(len(levels))])
+// replaceTextures replaces the level's textures with the
+// png images in the directory. The images are named after
+// the texture with # in place of * as used by most tools
+// e.g. #water1.png.
+func replaceTextures(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	images := map[string]image.Image{}
+	for _, info := range infos {
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(info.Name()), ".png") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return err
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", info.Name(), err)
+		}
+		name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		images[strings.Replace(name, "#", "*", -1)] = img
+	}
+	return render.ReplaceTextures(images)
+}
+
+// axis converts a pair of opposing keys into a value
+// between -1 and 1
+func axis(positive, negative bool) float32 {
+	var v float32
+	if positive {
+		v++
+	}
+	if negative {
+		v--
 	}
+	return v
 }
 
 func onMouseMove(w *glfw.Window, xpos float64, ypos float64) {
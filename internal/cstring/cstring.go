@@ -0,0 +1,14 @@
+// Package cstring converts the fixed size, null terminated
+// strings used by Quake's file formats
+package cstring
+
+// String returns the bytes before the first null byte, or
+// all of them if there isn't one.
+func String(b []byte) string {
+	for i := 0; i < len(b); i++ {
+		if b[i] == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
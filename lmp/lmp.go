@@ -0,0 +1,151 @@
+// Package lmp provides methods to read Quake's picture,
+// palette and colour map lumps and convert pictures to
+// images.
+package lmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/thinkofdeath/goquake/bsp"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	// ColourMapLevels is the number of light levels in a
+	// colour map
+	ColourMapLevels = 64
+	// NormalLight is the light level of the colour map that
+	// leaves colours unchanged
+	NormalLight = 32
+	// FirstFullbright is the first palette index that isn't
+	// affected by lighting
+	FirstFullbright = bsp.FirstFullbright
+	// Transparent is the palette index used for transparent
+	// pixels
+	Transparent = 255
+)
+
+var (
+	// ErrInvalid is returned when a lump is invalid
+	ErrInvalid = errors.New("Invalid LMP file")
+)
+
+// Palette is the 256 colours (RGB triples) used by all of
+// Quake's pictures.
+type Palette [256 * 3]byte
+
+// Colour returns the colour at the index in the palette
+func (p *Palette) Colour(index byte) color.RGBA {
+	i := int(index) * 3
+	return color.RGBA{p[i], p[i+1], p[i+2], 0xFF}
+}
+
+// ColourMap maps palette indices to the index used at
+// each light level, level 0 being the brightest.
+type ColourMap [ColourMapLevels * 256]byte
+
+// Lookup returns the palette index to use for the index at
+// the light level.
+func (c *ColourMap) Lookup(index byte, light int) byte {
+	if light < 0 {
+		light = 0
+	} else if light >= ColourMapLevels {
+		light = ColourMapLevels - 1
+	}
+	return c[light*256+int(index)]
+}
+
+// ParsePalette parses a palette lump (gfx/palette.lmp)
+func ParsePalette(r io.Reader) (*Palette, error) {
+	p := &Palette{}
+	_, err := io.ReadFull(r, p[:])
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParseColourMap parses a colour map lump (gfx/colormap.lmp)
+func ParseColourMap(r io.Reader) (*ColourMap, error) {
+	c := &ColourMap{}
+	_, err := io.ReadFull(r, c[:])
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParsePicture parses a qpic, the format used by the
+// pictures in gfx/ and by the picture lumps in gfx.wad.
+func ParsePicture(r *io.SectionReader) (*bsp.Picture, error) {
+	var header struct {
+		Width, Height int32
+	}
+	err := binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Width < 0 || header.Height < 0 ||
+		int64(header.Width)*int64(header.Height) > r.Size()-int64(binary.Size(header)) {
+		return nil, ErrInvalid
+	}
+
+	pic := &bsp.Picture{
+		Width:  int(header.Width),
+		Height: int(header.Height),
+		Data:   make([]byte, int(header.Width)*int(header.Height)),
+	}
+	_, err = io.ReadFull(r, pic.Data)
+	if err != nil {
+		return nil, err
+	}
+	return pic, nil
+}
+
+// Options controls how pictures are converted into images
+type Options struct {
+	// ColourMap is used to light the picture, if nil the
+	// picture's colours are used as is.
+	ColourMap *ColourMap
+	// Light is the level of the colour map to use, see
+	// NormalLight.
+	Light int
+	// Fullbright causes fullbright colours to ignore the
+	// light level.
+	Fullbright bool
+	// Transparent causes the transparent index to have an
+	// alpha of 0.
+	Transparent bool
+}
+
+// Image converts the palette indexed picture to an image.
+// opts may be nil to use the picture's colours as is.
+// Pixels missing from the picture's data are left
+// transparent.
+func Image(pic *bsp.Picture, p *Palette, opts *Options) *image.RGBA {
+	if opts == nil {
+		opts = &Options{}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, pic.Width, pic.Height))
+	data := pic.Data
+	if n := len(img.Pix) / 4; len(data) > n {
+		data = data[:n]
+	}
+	for i, index := range data {
+		if opts.Transparent && index == Transparent {
+			continue
+		}
+		col := index
+		if opts.ColourMap != nil && !(opts.Fullbright && index >= FirstFullbright) {
+			col = opts.ColourMap.Lookup(index, opts.Light)
+		}
+		c := p.Colour(col)
+		img.Pix[i*4+0] = c.R
+		img.Pix[i*4+1] = c.G
+		img.Pix[i*4+2] = c.B
+		img.Pix[i*4+3] = c.A
+	}
+	return img
+}
@@ -0,0 +1,49 @@
+package lmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/thinkofdeath/goquake/bsp"
+	"io"
+	"testing"
+)
+
+func parseTestPicture(width, height int32, data []byte) (*bsp.Picture, error) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, [2]int32{width, height})
+	b.Write(data)
+	return ParsePicture(io.NewSectionReader(bytes.NewReader(b.Bytes()), 0, int64(b.Len())))
+}
+
+func TestParsePicture(t *testing.T) {
+	pic, err := parseTestPicture(2, 2, []byte{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pic.Width != 2 || pic.Height != 2 || !bytes.Equal(pic.Data, []byte{1, 2, 3, 4}) {
+		t.Errorf("unexpected picture %+v", pic)
+	}
+
+	tests := []struct {
+		name          string
+		width, height int32
+	}{
+		{"negative", -1, 2},
+		{"too large", 3, 2},
+		// Overflows int32 when multiplied
+		{"overflow", 46341, 46341},
+	}
+	for _, test := range tests {
+		if _, err := parseTestPicture(test.width, test.height, []byte{1, 2, 3, 4}); err != ErrInvalid {
+			t.Errorf("%s: expected ErrInvalid, got %v", test.name, err)
+		}
+	}
+}
+
+func TestImageShortData(t *testing.T) {
+	var p Palette
+	img := Image(&bsp.Picture{Width: 2, Height: 2, Data: []byte{1}}, &p, nil)
+	if img.Pix[3] != 255 || img.Pix[7] != 0 {
+		t.Errorf("unexpected pixels %v", img.Pix)
+	}
+}
@@ -0,0 +1,370 @@
+// Package mdl provides methods to read Quake's alias models
+package mdl
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/internal/cstring"
+	"github.com/thinkofdeath/goquake/vmath"
+	"io"
+)
+
+const (
+	mdlMagic   = "IDPO"
+	mdlVersion = 6
+)
+
+var (
+	// ErrInvalid is returned when the model file is invalid
+	ErrInvalid = errors.New("Invalid MDL file")
+)
+
+// Effect flags that can be set on a model
+const (
+	FlagRocket = 1 << iota
+	FlagGrenade
+	FlagGib
+	FlagRotate
+	FlagTracer
+	FlagZombieGib
+	FlagTracer2
+	FlagTracer3
+)
+
+// Model is an animated model made up of triangles.
+type Model struct {
+	Scale          vmath.Vector3
+	Translate      vmath.Vector3
+	BoundingRadius float32
+	EyePosition    vmath.Vector3
+	SkinWidth      int
+	SkinHeight     int
+	Skins          []*Skin
+	TexCoords      []TexCoord
+	Triangles      []Triangle
+	Frames         []*FrameGroup
+	SyncType       int
+	Flags          int
+	Size           float32
+}
+
+// Skin is a texture for a model. Animated skins contain
+// multiple pictures with the time (in seconds) that
+// each one ends at.
+type Skin struct {
+	Pictures  []*bsp.Picture
+	Intervals []float32
+}
+
+// TexCoord is the location of a vertex on the skin.
+// Vertices on the seam have S offset by half of the
+// skin's width when used by back facing triangles.
+type TexCoord struct {
+	OnSeam bool
+	S, T   int
+}
+
+// Triangle is a single triangle of the model, the vertices
+// index both the TexCoords and each frame's Vertices.
+type Triangle struct {
+	FacesFront bool
+	Vertices   [3]int
+}
+
+// Vertex is a packed vertex position. The position needs
+// to be scaled by the model's Scale and offset by its
+// Translate to get the actual position.
+type Vertex struct {
+	Position    [3]uint8
+	NormalIndex uint8
+}
+
+// Frame is a single pose of the model.
+type Frame struct {
+	Name     string
+	Min, Max Vertex
+	Vertices []Vertex
+	// Positions contains the unpacked positions of the
+	// vertices.
+	Positions []vmath.Vector3
+}
+
+// FrameGroup is a frame of the model. Frames that are
+// animated on their own contain multiple frames with the
+// time (in seconds) that each one ends at.
+type FrameGroup struct {
+	Min, Max  Vertex
+	Frames    []*Frame
+	Intervals []float32
+}
+
+// ParseMDLFile parses an alias model from the reader.
+func ParseMDLFile(r *io.SectionReader) (m *Model, err error) {
+	var header mdlHeader
+	err = binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return
+	}
+
+	if string(header.Magic[:]) != mdlMagic {
+		err = ErrInvalid
+		return
+	}
+	if header.Version != mdlVersion {
+		err = errors.New("unsupported version")
+		return
+	}
+	if header.NumSkins < 0 || header.SkinWidth < 0 || header.SkinHeight < 0 ||
+		header.NumVerts < 0 || header.NumTris < 0 || header.NumFrames < 0 {
+		err = ErrInvalid
+		return
+	}
+	// Every element takes up at least this much of the file,
+	// checked before allocating space for them
+	size := r.Size()
+	if int64(header.SkinWidth)*int64(header.SkinHeight) > size ||
+		int64(header.NumSkins)*4 > size ||
+		int64(header.NumVerts)*int64(binary.Size(texCoordData{})) > size ||
+		int64(header.NumTris)*int64(binary.Size(triangleData{})) > size ||
+		int64(header.NumFrames)*4 > size {
+		err = ErrInvalid
+		return
+	}
+
+	m = &Model{
+		Scale:          header.Scale,
+		Translate:      header.Translate,
+		BoundingRadius: header.BoundingRadius,
+		EyePosition:    header.EyePosition,
+		SkinWidth:      int(header.SkinWidth),
+		SkinHeight:     int(header.SkinHeight),
+		SyncType:       int(header.SyncType),
+		Flags:          int(header.Flags),
+		Size:           header.Size,
+	}
+
+	err = m.parseSkins(r, int(header.NumSkins))
+	if err != nil {
+		return
+	}
+
+	err = m.parseTexCoords(r, int(header.NumVerts))
+	if err != nil {
+		return
+	}
+
+	err = m.parseTriangles(r, int(header.NumTris))
+	if err != nil {
+		return
+	}
+
+	err = m.parseFrames(r, int(header.NumFrames), int(header.NumVerts))
+	return
+}
+
+func (m *Model) parseSkins(r *io.SectionReader, count int) error {
+	m.Skins = make([]*Skin, count)
+	for i := 0; i < count; i++ {
+		var group int32
+		err := binary.Read(r, binary.LittleEndian, &group)
+		if err != nil {
+			return err
+		}
+
+		skin := &Skin{}
+		num := int32(1)
+		if group != 0 {
+			err = binary.Read(r, binary.LittleEndian, &num)
+			if err != nil {
+				return err
+			}
+			if num <= 0 || int64(num)*4 > r.Size() {
+				return ErrInvalid
+			}
+			skin.Intervals = make([]float32, num)
+			err = binary.Read(r, binary.LittleEndian, skin.Intervals)
+			if err != nil {
+				return err
+			}
+		}
+
+		skin.Pictures = make([]*bsp.Picture, num)
+		for j := range skin.Pictures {
+			pic := &bsp.Picture{
+				Width:  m.SkinWidth,
+				Height: m.SkinHeight,
+				Data:   make([]byte, m.SkinWidth*m.SkinHeight),
+			}
+			_, err = io.ReadFull(r, pic.Data)
+			if err != nil {
+				return err
+			}
+			skin.Pictures[j] = pic
+		}
+		m.Skins[i] = skin
+	}
+	return nil
+}
+
+type texCoordData struct {
+	OnSeam int32
+	S, T   int32
+}
+
+func (m *Model) parseTexCoords(r io.Reader, count int) error {
+	coords := make([]texCoordData, count)
+	err := binary.Read(r, binary.LittleEndian, coords)
+	if err != nil {
+		return err
+	}
+
+	m.TexCoords = make([]TexCoord, count)
+	for i, c := range coords {
+		m.TexCoords[i] = TexCoord{
+			OnSeam: c.OnSeam != 0,
+			S:      int(c.S),
+			T:      int(c.T),
+		}
+	}
+	return nil
+}
+
+type triangleData struct {
+	FacesFront int32
+	Vertices   [3]int32
+}
+
+func (m *Model) parseTriangles(r io.Reader, count int) error {
+	tris := make([]triangleData, count)
+	err := binary.Read(r, binary.LittleEndian, tris)
+	if err != nil {
+		return err
+	}
+
+	m.Triangles = make([]Triangle, count)
+	for i, t := range tris {
+		m.Triangles[i] = Triangle{
+			FacesFront: t.FacesFront != 0,
+			Vertices:   [3]int{int(t.Vertices[0]), int(t.Vertices[1]), int(t.Vertices[2])},
+		}
+		for _, v := range t.Vertices {
+			if v < 0 || int(v) >= len(m.TexCoords) {
+				return ErrInvalid
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Model) parseFrames(r *io.SectionReader, count, numVerts int) error {
+	m.Frames = make([]*FrameGroup, count)
+	for i := 0; i < count; i++ {
+		var group int32
+		err := binary.Read(r, binary.LittleEndian, &group)
+		if err != nil {
+			return err
+		}
+
+		if group == 0 {
+			f, err := m.parseFrame(r, numVerts)
+			if err != nil {
+				return err
+			}
+			m.Frames[i] = &FrameGroup{
+				Min:    f.Min,
+				Max:    f.Max,
+				Frames: []*Frame{f},
+			}
+			continue
+		}
+
+		var header frameGroupData
+		err = binary.Read(r, binary.LittleEndian, &header)
+		if err != nil {
+			return err
+		}
+		if header.Num <= 0 || int64(header.Num)*4 > r.Size() {
+			return ErrInvalid
+		}
+		g := &FrameGroup{
+			Min:       header.Min,
+			Max:       header.Max,
+			Frames:    make([]*Frame, header.Num),
+			Intervals: make([]float32, header.Num),
+		}
+		err = binary.Read(r, binary.LittleEndian, g.Intervals)
+		if err != nil {
+			return err
+		}
+		for j := range g.Frames {
+			g.Frames[j], err = m.parseFrame(r, numVerts)
+			if err != nil {
+				return err
+			}
+		}
+		m.Frames[i] = g
+	}
+	return nil
+}
+
+func (m *Model) parseFrame(r io.Reader, numVerts int) (*Frame, error) {
+	var header frameData
+	err := binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Frame{
+		Name:      cstring.String(header.Name[:]),
+		Min:       header.Min,
+		Max:       header.Max,
+		Vertices:  make([]Vertex, numVerts),
+		Positions: make([]vmath.Vector3, numVerts),
+	}
+	err = binary.Read(r, binary.LittleEndian, f.Vertices)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range f.Vertices {
+		f.Positions[i] = m.Unpack(v)
+	}
+	return f, nil
+}
+
+// Unpack returns the actual position of the packed vertex
+func (m *Model) Unpack(v Vertex) vmath.Vector3 {
+	return vmath.Vector3{
+		X: m.Scale.X*float32(v.Position[0]) + m.Translate.X,
+		Y: m.Scale.Y*float32(v.Position[1]) + m.Translate.Y,
+		Z: m.Scale.Z*float32(v.Position[2]) + m.Translate.Z,
+	}
+}
+
+type mdlHeader struct {
+	Magic          [4]byte
+	Version        int32
+	Scale          vmath.Vector3
+	Translate      vmath.Vector3
+	BoundingRadius float32
+	EyePosition    vmath.Vector3
+	NumSkins       int32
+	SkinWidth      int32
+	SkinHeight     int32
+	NumVerts       int32
+	NumTris        int32
+	NumFrames      int32
+	SyncType       int32
+	Flags          int32
+	Size           float32
+}
+
+type frameData struct {
+	Min, Max Vertex
+	Name     [16]byte
+}
+
+type frameGroupData struct {
+	Num      int32
+	Min, Max Vertex
+}
@@ -0,0 +1,395 @@
+// Package movement implements Quake's player physics
+package movement
+
+import (
+	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/vmath"
+	"math"
+)
+
+// ViewHeight is the height of the player's eyes above
+// their origin.
+const ViewHeight = 22
+
+const (
+	// The maximum height the player can step up
+	stepSize = 18
+	// The maximum number of planes the player can be
+	// clipped against in a single move
+	maxClipPlanes = 5
+	// Velocities smaller than this are rounded to zero
+	stopEpsilon = 0.1
+)
+
+// The size of the player's bounding box, this matches
+// bsp.HullPlayer
+var (
+	playerMins = vmath.Vector3{X: -16, Y: -16, Z: -24}
+	playerMaxs = vmath.Vector3{X: 16, Y: 16, Z: 32}
+)
+
+// World is the level the player moves through. bsp.File
+// implements this.
+type World interface {
+	Trace(start, end vmath.Vector3, hull int) bsp.TraceResult
+	PointContents(p vmath.Vector3) bsp.Contents
+}
+
+// Settings contains the tunable values used by the physics,
+// these match Quake's server cvars.
+type Settings struct {
+	Gravity      float32
+	Friction     float32
+	EdgeFriction float32
+	StopSpeed    float32
+	MaxSpeed     float32
+	Accelerate   float32
+	MaxVelocity  float32
+	JumpSpeed    float32
+}
+
+// DefaultSettings are the values Quake uses by default
+var DefaultSettings = Settings{
+	Gravity:      800,
+	Friction:     4,
+	EdgeFriction: 2,
+	StopSpeed:    100,
+	MaxSpeed:     320,
+	Accelerate:   10,
+	MaxVelocity:  2000,
+	JumpSpeed:    270,
+}
+
+// UserCmd is the input for a single physics frame.
+type UserCmd struct {
+	// ViewAngles contains the pitch, yaw and roll of the
+	// player's view in degrees. Positive pitch looks down.
+	ViewAngles vmath.Vector3
+	// The requested movement speeds along the view, Quake
+	// uses 200 (400 when running) for forward movement.
+	ForwardMove float32
+	SideMove    float32
+	UpMove      float32
+	Jump        bool
+}
+
+// Player is the physics state of a single player.
+type Player struct {
+	Settings Settings
+
+	Origin   vmath.Vector3
+	Velocity vmath.Vector3
+	OnGround bool
+	// WaterLevel is how submerged the player is. 0 is not
+	// in water, 1 is feet, 2 is waist and 3 is fully
+	// under water.
+	WaterLevel int
+	WaterType  bsp.Contents
+	// NoClip allows the player to fly through walls
+	NoClip bool
+
+	time          float32
+	oldOrigin     vmath.Vector3
+	jumpReleased  bool
+	waterJump     bool
+	waterJumpTime float32
+	moveDir       vmath.Vector3
+}
+
+// NewPlayer creates a player standing at the passed origin
+// using the default settings.
+func NewPlayer(origin vmath.Vector3) *Player {
+	return &Player{
+		Settings:     DefaultSettings,
+		Origin:       origin,
+		oldOrigin:    origin,
+		WaterType:    bsp.ContentsEmpty,
+		jumpReleased: true,
+	}
+}
+
+// Move runs a single physics frame of frameTime seconds
+// for the player. The result only depends on the inputs
+// so running with a fixed frameTime is deterministic.
+func (p *Player) Move(w World, cmd UserCmd, frameTime float32) {
+	p.time += frameTime
+
+	p.think(w, cmd, frameTime)
+	p.preThink(w, cmd)
+	p.checkVelocity()
+
+	if p.NoClip {
+		p.OnGround = false
+		p.Origin = p.Origin.Add(p.Velocity.Scale(frameTime))
+		return
+	}
+
+	if !p.checkWater(w) && !p.waterJump {
+		p.Velocity.Z -= p.Settings.Gravity * frameTime
+	}
+	p.checkStuck(w)
+	p.walkMove(w, frameTime, cmd.ViewAngles)
+}
+
+// think applies the player's input to their velocity,
+// SV_ClientThink in Quake.
+func (p *Player) think(w World, cmd UserCmd, frameTime float32) {
+	if p.waterJump {
+		p.waterJumpMove()
+		return
+	}
+	if p.WaterLevel >= 2 && !p.NoClip {
+		p.waterMove(cmd, frameTime)
+		return
+	}
+	p.airMove(w, cmd, frameTime)
+}
+
+func (p *Player) waterJumpMove() {
+	if p.time > p.waterJumpTime || p.WaterLevel == 0 {
+		p.waterJump = false
+		p.waterJumpTime = 0
+	}
+	p.Velocity.X = p.moveDir.X
+	p.Velocity.Y = p.moveDir.Y
+}
+
+func (p *Player) waterMove(cmd UserCmd, frameTime float32) {
+	forward, right, _ := vmath.AngleVectors(cmd.ViewAngles)
+	wishVel := forward.Scale(cmd.ForwardMove).Add(right.Scale(cmd.SideMove))
+
+	if cmd.ForwardMove == 0 && cmd.SideMove == 0 && cmd.UpMove == 0 {
+		// Drift towards the bottom
+		wishVel.Z -= 60
+	} else {
+		wishVel.Z += cmd.UpMove
+	}
+
+	wishSpeed := wishVel.Length()
+	if wishSpeed > p.Settings.MaxSpeed {
+		wishVel = wishVel.Scale(p.Settings.MaxSpeed / wishSpeed)
+		wishSpeed = p.Settings.MaxSpeed
+	}
+	wishSpeed *= 0.7
+
+	// Water friction
+	var newSpeed float32
+	if speed := p.Velocity.Length(); speed != 0 {
+		newSpeed = speed - frameTime*speed*p.Settings.Friction
+		if newSpeed < 0 {
+			newSpeed = 0
+		}
+		p.Velocity = p.Velocity.Scale(newSpeed / speed)
+	}
+
+	// Water acceleration
+	if wishSpeed == 0 {
+		return
+	}
+	addSpeed := wishSpeed - newSpeed
+	if addSpeed <= 0 {
+		return
+	}
+	wishDir, _ := wishVel.Normalize()
+	accelSpeed := p.Settings.Accelerate * wishSpeed * frameTime
+	if accelSpeed > addSpeed {
+		accelSpeed = addSpeed
+	}
+	p.Velocity = p.Velocity.Add(wishDir.Scale(accelSpeed))
+}
+
+func (p *Player) airMove(w World, cmd UserCmd, frameTime float32) {
+	angles := cmd.ViewAngles
+	if !p.NoClip {
+		// Quake only uses a third of the view's pitch for
+		// the player's body
+		angles.X /= 3
+	}
+	forward, right, _ := vmath.AngleVectors(angles)
+
+	wishVel := forward.Scale(cmd.ForwardMove).Add(right.Scale(cmd.SideMove))
+	if p.NoClip {
+		wishVel.Z += cmd.UpMove
+	} else {
+		wishVel.Z = 0
+	}
+
+	wishDir, wishSpeed := wishVel.Normalize()
+	if wishSpeed > p.Settings.MaxSpeed {
+		wishVel = wishVel.Scale(p.Settings.MaxSpeed / wishSpeed)
+		wishSpeed = p.Settings.MaxSpeed
+	}
+
+	switch {
+	case p.NoClip:
+		p.Velocity = wishVel
+	case p.OnGround:
+		p.userFriction(w, frameTime)
+		p.accelerate(wishDir, wishSpeed, frameTime)
+	default:
+		p.airAccelerate(wishVel, wishSpeed, frameTime)
+	}
+}
+
+func (p *Player) userFriction(w World, frameTime float32) {
+	speed := float32(math.Hypot(float64(p.Velocity.X), float64(p.Velocity.Y)))
+	if speed == 0 {
+		return
+	}
+
+	// If the leading edge is over a drop off increase the
+	// friction
+	start := vmath.Vector3{
+		X: p.Origin.X + p.Velocity.X/speed*16,
+		Y: p.Origin.Y + p.Velocity.Y/speed*16,
+		Z: p.Origin.Z + playerMins.Z,
+	}
+	stop := start
+	stop.Z -= 34
+
+	friction := p.Settings.Friction
+	if w.Trace(start, stop, bsp.HullPoint).Fraction == 1 {
+		friction *= p.Settings.EdgeFriction
+	}
+
+	control := speed
+	if control < p.Settings.StopSpeed {
+		control = p.Settings.StopSpeed
+	}
+	newSpeed := speed - frameTime*control*friction
+	if newSpeed < 0 {
+		newSpeed = 0
+	}
+	p.Velocity = p.Velocity.Scale(newSpeed / speed)
+}
+
+func (p *Player) accelerate(wishDir vmath.Vector3, wishSpeed, frameTime float32) {
+	addSpeed := wishSpeed - p.Velocity.Dot(wishDir)
+	if addSpeed <= 0 {
+		return
+	}
+	accelSpeed := p.Settings.Accelerate * frameTime * wishSpeed
+	if accelSpeed > addSpeed {
+		accelSpeed = addSpeed
+	}
+	p.Velocity = p.Velocity.Add(wishDir.Scale(accelSpeed))
+}
+
+func (p *Player) airAccelerate(wishVel vmath.Vector3, wishSpeed, frameTime float32) {
+	wishDir, wishSpd := wishVel.Normalize()
+	// Air control is limited to a small amount
+	if wishSpd > 30 {
+		wishSpd = 30
+	}
+	addSpeed := wishSpd - p.Velocity.Dot(wishDir)
+	if addSpeed <= 0 {
+		return
+	}
+	accelSpeed := p.Settings.Accelerate * wishSpeed * frameTime
+	if accelSpeed > addSpeed {
+		accelSpeed = addSpeed
+	}
+	p.Velocity = p.Velocity.Add(wishDir.Scale(accelSpeed))
+}
+
+// preThink handles jumping, this is done by the game's
+// progs in Quake (PlayerPreThink).
+func (p *Player) preThink(w World, cmd UserCmd) {
+	if p.NoClip {
+		return
+	}
+	if p.WaterLevel == 2 {
+		p.checkWaterJump(w, cmd)
+	}
+	if !cmd.Jump {
+		p.jumpReleased = true
+		return
+	}
+
+	if p.waterJump {
+		return
+	}
+	if p.WaterLevel >= 2 {
+		// Swim upwards
+		switch p.WaterType {
+		case bsp.ContentsWater:
+			p.Velocity.Z = 100
+		case bsp.ContentsSlime:
+			p.Velocity.Z = 80
+		default:
+			p.Velocity.Z = 50
+		}
+		return
+	}
+	if !p.OnGround || !p.jumpReleased {
+		return
+	}
+	p.jumpReleased = false
+	p.OnGround = false
+	p.Velocity.Z += p.Settings.JumpSpeed
+}
+
+// checkWaterJump allows the player to jump out of water
+// when they are against a ledge that they could climb
+// onto.
+func (p *Player) checkWaterJump(w World, cmd UserCmd) {
+	forward, _, _ := vmath.AngleVectors(vmath.Vector3{Y: cmd.ViewAngles.Y})
+	forward.Z = 0
+	forward, _ = forward.Normalize()
+
+	start := p.Origin
+	start.Z += 8
+	trace := w.Trace(start, start.Add(forward.Scale(24)), bsp.HullPoint)
+	if trace.Fraction == 1 {
+		return
+	}
+
+	// Solid at the waist, check for space at eye level
+	start.Z += playerMaxs.Z - 8
+	p.moveDir = trace.Plane.Normal.Scale(-50)
+	if w.Trace(start, start.Add(forward.Scale(24)), bsp.HullPoint).Fraction == 1 {
+		p.waterJump = true
+		p.Velocity.Z = 225
+		p.jumpReleased = false
+		p.waterJumpTime = p.time + 2
+	}
+}
+
+func (p *Player) checkVelocity() {
+	max := p.Settings.MaxVelocity
+	clamp := func(v float32) float32 {
+		if v > max {
+			return max
+		}
+		if v < -max {
+			return -max
+		}
+		return v
+	}
+	p.Velocity.X = clamp(p.Velocity.X)
+	p.Velocity.Y = clamp(p.Velocity.Y)
+	p.Velocity.Z = clamp(p.Velocity.Z)
+}
+
+// checkWater updates the player's water level and returns
+// whether they are swimming.
+func (p *Player) checkWater(w World) bool {
+	p.WaterLevel = 0
+	p.WaterType = bsp.ContentsEmpty
+
+	point := p.Origin
+	point.Z += playerMins.Z + 1
+	if c := w.PointContents(point); c <= bsp.ContentsWater {
+		p.WaterType = c
+		p.WaterLevel = 1
+		point.Z = p.Origin.Z + (playerMins.Z+playerMaxs.Z)*0.5
+		if c := w.PointContents(point); c <= bsp.ContentsWater {
+			p.WaterLevel = 2
+			point.Z = p.Origin.Z + ViewHeight
+			if c := w.PointContents(point); c <= bsp.ContentsWater {
+				p.WaterLevel = 3
+			}
+		}
+	}
+	return p.WaterLevel > 1
+}
@@ -0,0 +1,249 @@
+package movement
+
+import (
+	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/vmath"
+	"math"
+	"testing"
+)
+
+// All tests run at Quake's default frame rate
+const frameTime = 1.0 / 72
+
+// Like Quake's traces stop just short of the plane hit
+const distEpsilon = 0.03125
+
+type box struct {
+	min, max vmath.Vector3
+}
+
+// boxWorld is a world made of solid axis aligned boxes
+// with empty space around them.
+type boxWorld []box
+
+func (w boxWorld) Trace(start, end vmath.Vector3, hull int) bsp.TraceResult {
+	result := bsp.TraceResult{Fraction: 1, EndPos: end}
+	delta := end.Sub(start)
+	for _, b := range w {
+		if hull == bsp.HullPlayer {
+			// Tracing the player's box is the same as
+			// tracing a point against the boxes grown by
+			// the player's size
+			b.min = b.min.Sub(playerMaxs)
+			b.max = b.max.Sub(playerMins)
+		}
+		if b.contains(start) {
+			result.StartSolid = true
+			if b.contains(end) {
+				result.AllSolid = true
+			}
+			continue
+		}
+		fraction, normal, ok := b.intersect(start, delta)
+		if !ok || fraction >= result.Fraction {
+			continue
+		}
+		length := delta.Length()
+		fraction = (fraction*length - distEpsilon) / length
+		if fraction < 0 {
+			fraction = 0
+		}
+		result.Fraction = fraction
+		result.EndPos = start.Add(delta.Scale(fraction))
+		result.Plane = bsp.Plane{Normal: normal, Dist: normal.Dot(result.EndPos)}
+	}
+	return result
+}
+
+func (w boxWorld) PointContents(p vmath.Vector3) bsp.Contents {
+	for _, b := range w {
+		if b.contains(p) {
+			return bsp.ContentsSolid
+		}
+	}
+	return bsp.ContentsEmpty
+}
+
+// contains returns whether the point is inside the box,
+// points on its surface are outside.
+func (b box) contains(p vmath.Vector3) bool {
+	return p.X > b.min.X && p.X < b.max.X &&
+		p.Y > b.min.Y && p.Y < b.max.Y &&
+		p.Z > b.min.Z && p.Z < b.max.Z
+}
+
+// intersect returns the fraction of delta at which the
+// line from start enters the box and the normal of the
+// face it enters through.
+func (b box) intersect(start, delta vmath.Vector3) (float32, vmath.Vector3, bool) {
+	s := [3]float32{start.X, start.Y, start.Z}
+	d := [3]float32{delta.X, delta.Y, delta.Z}
+	min := [3]float32{b.min.X, b.min.Y, b.min.Z}
+	max := [3]float32{b.max.X, b.max.Y, b.max.Z}
+
+	enter, exit := float32(-1), float32(2)
+	axis, side := -1, float32(0)
+	for i := 0; i < 3; i++ {
+		if d[i] == 0 {
+			if s[i] <= min[i] || s[i] >= max[i] {
+				return 0, vmath.Vector3{}, false
+			}
+			continue
+		}
+		near, far := (min[i]-s[i])/d[i], (max[i]-s[i])/d[i]
+		normal := float32(-1)
+		if near > far {
+			near, far = far, near
+			normal = 1
+		}
+		if near > enter {
+			enter, axis, side = near, i, normal
+		}
+		if far < exit {
+			exit = far
+		}
+	}
+	if axis == -1 || enter >= exit || enter < 0 || enter > 1 {
+		return 0, vmath.Vector3{}, false
+	}
+	var n [3]float32
+	n[axis] = side
+	return enter, vmath.Vector3{X: n[0], Y: n[1], Z: n[2]}, true
+}
+
+// A large floor with its top at z = 0
+var floor = box{
+	min: vmath.Vector3{X: -4096, Y: -4096, Z: -64},
+	max: vmath.Vector3{X: 4096, Y: 4096, Z: 0},
+}
+
+// standingHeight is the origin's height when stood on
+// the floor
+var standingHeight = -playerMins.Z
+
+func run(p *Player, w World, cmd UserCmd, frames int) {
+	for i := 0; i < frames; i++ {
+		p.Move(w, cmd, frameTime)
+	}
+}
+
+func near(a, b, tolerance float32) bool {
+	return math.Abs(float64(a-b)) <= float64(tolerance)
+}
+
+func TestLandOnFloor(t *testing.T) {
+	w := boxWorld{floor}
+	p := NewPlayer(vmath.Vector3{Z: 100})
+	run(p, w, UserCmd{}, 72)
+	if !p.OnGround || !near(p.Origin.Z, standingHeight, 0.1) {
+		t.Fatalf("expected to be stood on the floor, origin %v on ground %v", p.Origin, p.OnGround)
+	}
+	if p.Velocity.Z > 0 || p.Velocity.Z < -p.Settings.Gravity*frameTime {
+		t.Errorf("expected to be at rest, velocity %v", p.Velocity)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	w := boxWorld{floor}
+	cmd := UserCmd{ViewAngles: vmath.Vector3{Y: 30}, ForwardMove: 400, SideMove: 100}
+	a := NewPlayer(vmath.Vector3{Z: standingHeight})
+	b := NewPlayer(vmath.Vector3{Z: standingHeight})
+	run(a, w, cmd, 100)
+	run(b, w, cmd, 100)
+	if a.Origin != b.Origin || a.Velocity != b.Velocity {
+		t.Fatalf("runs differ: %v %v and %v %v", a.Origin, a.Velocity, b.Origin, b.Velocity)
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	step := func(height float32) box {
+		return box{
+			min: vmath.Vector3{X: 200, Y: -4096, Z: -64},
+			max: vmath.Vector3{X: 4096, Y: 4096, Z: height},
+		}
+	}
+
+	w := boxWorld{floor, step(stepSize)}
+	p := NewPlayer(vmath.Vector3{Z: standingHeight})
+	run(p, w, UserCmd{ForwardMove: 400}, 144)
+	if p.Origin.X < 250 || !near(p.Origin.Z, stepSize+standingHeight, 0.1) {
+		t.Fatalf("expected to climb the step, origin %v", p.Origin)
+	}
+
+	// Anything taller blocks the player
+	w = boxWorld{floor, step(stepSize + 6)}
+	p = NewPlayer(vmath.Vector3{Z: standingHeight})
+	run(p, w, UserCmd{ForwardMove: 400}, 144)
+	if p.Origin.X > 200+playerMins.X || !near(p.Origin.Z, standingHeight, 0.1) {
+		t.Fatalf("expected to be blocked by the step, origin %v", p.Origin)
+	}
+}
+
+func TestSlideAlongWall(t *testing.T) {
+	wall := box{
+		min: vmath.Vector3{X: 100, Y: -4096, Z: -64},
+		max: vmath.Vector3{X: 200, Y: 4096, Z: 256},
+	}
+	w := boxWorld{floor, wall}
+	p := NewPlayer(vmath.Vector3{Z: standingHeight})
+	// Run diagonally into the wall
+	run(p, w, UserCmd{ViewAngles: vmath.Vector3{Y: 45}, ForwardMove: 400}, 144)
+	if !near(p.Origin.X, wall.min.X-playerMaxs.X, 0.1) {
+		t.Errorf("expected to be against the wall, origin %v", p.Origin)
+	}
+	if p.Origin.Y < 200 || p.Velocity.Y <= 0 {
+		t.Errorf("expected to slide along the wall, origin %v velocity %v", p.Origin, p.Velocity)
+	}
+	if !p.OnGround {
+		t.Error("expected to stay on the ground")
+	}
+}
+
+func TestJump(t *testing.T) {
+	w := boxWorld{floor}
+	p := NewPlayer(vmath.Vector3{Z: standingHeight})
+	run(p, w, UserCmd{}, 1)
+
+	p.Move(w, UserCmd{Jump: true}, frameTime)
+	if p.OnGround || p.Velocity.Z <= 0 {
+		t.Fatalf("expected to jump, velocity %v", p.Velocity)
+	}
+
+	// Holding jump doesn't jump again after landing
+	peak := p.Origin.Z
+	for i := 0; i < 144; i++ {
+		p.Move(w, UserCmd{Jump: true}, frameTime)
+		if p.Origin.Z > peak {
+			peak = p.Origin.Z
+		}
+	}
+	// v² / 2g
+	height := p.Settings.JumpSpeed * p.Settings.JumpSpeed / (2 * p.Settings.Gravity)
+	if !near(peak-standingHeight, height, 5) {
+		t.Errorf("expected a jump of about %v, got %v", height, peak-standingHeight)
+	}
+	if !p.OnGround || !near(p.Origin.Z, standingHeight, 0.1) {
+		t.Errorf("expected to land, origin %v", p.Origin)
+	}
+}
+
+func TestFrictionStops(t *testing.T) {
+	w := boxWorld{floor}
+	p := NewPlayer(vmath.Vector3{Z: standingHeight})
+	run(p, w, UserCmd{}, 1)
+
+	p.Velocity.X = 320
+	run(p, w, UserCmd{}, 72)
+	if p.Velocity.X != 0 || p.Velocity.Y != 0 {
+		t.Fatalf("expected to stop, velocity %v", p.Velocity)
+	}
+	if p.Origin.X <= 0 || p.Origin.X > 100 {
+		t.Errorf("expected to slide a short distance, origin %v", p.Origin)
+	}
+
+	stopped := p.Origin
+	run(p, w, UserCmd{}, 72)
+	if p.Origin.X != stopped.X || p.Origin.Y != stopped.Y {
+		t.Errorf("expected to stay still, moved from %v to %v", stopped, p.Origin)
+	}
+}
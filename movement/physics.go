@@ -0,0 +1,276 @@
+package movement
+
+import (
+	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/vmath"
+)
+
+// Flags returned from flyMove describing what blocked
+// the move
+const (
+	blockedFloor = 1 << iota
+	blockedStep
+	blockedAll = 7
+)
+
+// walkMove moves the player along their velocity, stepping
+// up stairs if required. SV_WalkMove in Quake.
+func (p *Player) walkMove(w World, frameTime float32, viewAngles vmath.Vector3) {
+	oldOnGround := p.OnGround
+	p.OnGround = false
+
+	oldOrigin := p.Origin
+	oldVelocity := p.Velocity
+
+	var stepTrace bsp.TraceResult
+	clip := p.flyMove(w, frameTime, &stepTrace)
+	if clip&blockedStep == 0 {
+		// The move didn't get blocked by a step
+		return
+	}
+	if !oldOnGround && p.WaterLevel == 0 {
+		// Don't step up whilst jumping
+		return
+	}
+	if p.waterJump {
+		return
+	}
+
+	noStepOrigin := p.Origin
+	noStepVelocity := p.Velocity
+
+	// Try moving up and forward to go up a step
+	p.Origin = oldOrigin
+	p.push(w, vmath.Vector3{Z: stepSize})
+
+	p.Velocity = vmath.Vector3{X: oldVelocity.X, Y: oldVelocity.Y}
+	clip = p.flyMove(w, frameTime, &stepTrace)
+
+	// Check for getting stuck, possibly due to the limited
+	// precision of floats in the clipping hulls
+	if clip != 0 && abs(oldOrigin.Y-p.Origin.Y) < 0.03125 && abs(oldOrigin.X-p.Origin.X) < 0.03125 {
+		clip = p.tryUnstick(w, oldVelocity)
+	}
+
+	// Extra friction based on the view angle
+	if clip&blockedStep != 0 {
+		p.wallFriction(&stepTrace, viewAngles)
+	}
+
+	// Move back down
+	down := p.push(w, vmath.Vector3{Z: -stepSize + oldVelocity.Z*frameTime})
+	if down.Plane.Normal.Z > 0.7 {
+		p.OnGround = true
+		return
+	}
+	// The step didn't end up on good ground so use the
+	// move without the step. This happens near wall/slope
+	// combinations and would allow the player to climb
+	// slopes that are too steep.
+	p.Origin = noStepOrigin
+	p.Velocity = noStepVelocity
+}
+
+// flyMove moves the player along their velocity sliding
+// along any planes that they hit. SV_FlyMove in Quake.
+func (p *Player) flyMove(w World, time float32, stepTrace *bsp.TraceResult) int {
+	var planes [maxClipPlanes]vmath.Vector3
+	numPlanes := 0
+	blocked := 0
+
+	originalVelocity := p.Velocity
+	primalVelocity := p.Velocity
+	timeLeft := time
+
+	for bump := 0; bump < 4; bump++ {
+		if p.Velocity == (vmath.Vector3{}) {
+			break
+		}
+
+		end := p.Origin.Add(p.Velocity.Scale(timeLeft))
+		trace := w.Trace(p.Origin, end, bsp.HullPlayer)
+
+		if trace.AllSolid {
+			// Trapped in a solid
+			p.Velocity = vmath.Vector3{}
+			return blockedFloor | blockedStep
+		}
+
+		if trace.Fraction > 0 {
+			// Actually covered some distance
+			p.Origin = trace.EndPos
+			originalVelocity = p.Velocity
+			numPlanes = 0
+		}
+
+		if trace.Fraction == 1 {
+			// Moved the entire distance
+			break
+		}
+
+		if trace.Plane.Normal.Z > 0.7 {
+			blocked |= blockedFloor
+			p.OnGround = true
+		}
+		if trace.Plane.Normal.Z == 0 {
+			blocked |= blockedStep
+			if stepTrace != nil {
+				*stepTrace = trace
+			}
+		}
+
+		timeLeft -= timeLeft * trace.Fraction
+
+		if numPlanes >= maxClipPlanes {
+			// Shouldn't really happen
+			p.Velocity = vmath.Vector3{}
+			return blockedFloor | blockedStep
+		}
+		planes[numPlanes] = trace.Plane.Normal
+		numPlanes++
+
+		// Modify the velocity so that it runs parallel to all
+		// of the clip planes
+		var newVelocity vmath.Vector3
+		i := 0
+		for ; i < numPlanes; i++ {
+			newVelocity = clipVelocity(originalVelocity, planes[i], 1)
+			j := 0
+			for ; j < numPlanes; j++ {
+				if j != i && newVelocity.Dot(planes[j]) < 0 {
+					break
+				}
+			}
+			if j == numPlanes {
+				break
+			}
+		}
+
+		if i != numPlanes {
+			// Go along this plane
+			p.Velocity = newVelocity
+		} else {
+			// Go along the crease
+			if numPlanes != 2 {
+				p.Velocity = vmath.Vector3{}
+				return blockedAll
+			}
+			dir := planes[0].Cross(planes[1])
+			p.Velocity = dir.Scale(dir.Dot(p.Velocity))
+		}
+
+		// If the velocity is against the original velocity
+		// then stop to avoid tiny oscillations in sloping
+		// corners
+		if p.Velocity.Dot(primalVelocity) <= 0 {
+			p.Velocity = vmath.Vector3{}
+			return blocked
+		}
+	}
+	return blocked
+}
+
+// clipVelocity removes the part of the velocity going into
+// the plane.
+func clipVelocity(in, normal vmath.Vector3, overBounce float32) vmath.Vector3 {
+	backOff := in.Dot(normal) * overBounce
+	out := in.Sub(normal.Scale(backOff))
+	if abs(out.X) < stopEpsilon {
+		out.X = 0
+	}
+	if abs(out.Y) < stopEpsilon {
+		out.Y = 0
+	}
+	if abs(out.Z) < stopEpsilon {
+		out.Z = 0
+	}
+	return out
+}
+
+// push moves the player by the passed amount, stopping if
+// they hit something
+func (p *Player) push(w World, move vmath.Vector3) bsp.TraceResult {
+	trace := w.Trace(p.Origin, p.Origin.Add(move), bsp.HullPlayer)
+	p.Origin = trace.EndPos
+	return trace
+}
+
+// tryUnstick attempts to free the player by nudging them
+// in each direction and retrying the move.
+func (p *Player) tryUnstick(w World, oldVelocity vmath.Vector3) int {
+	oldOrigin := p.Origin
+	dirs := [...]vmath.Vector3{
+		{X: 2}, {Y: 2}, {X: -2}, {Y: -2},
+		{X: 2, Y: 2}, {X: -2, Y: 2}, {X: 2, Y: -2}, {X: -2, Y: -2},
+	}
+	for _, dir := range dirs {
+		p.push(w, dir)
+
+		// Retry the original move
+		p.Velocity = vmath.Vector3{X: oldVelocity.X, Y: oldVelocity.Y}
+		var stepTrace bsp.TraceResult
+		clip := p.flyMove(w, 0.1, &stepTrace)
+		if abs(oldOrigin.Y-p.Origin.Y) > 4 || abs(oldOrigin.X-p.Origin.X) > 4 {
+			return clip
+		}
+
+		// Go back and try again
+		p.Origin = oldOrigin
+	}
+	p.Velocity = vmath.Vector3{}
+	return blockedAll
+}
+
+// wallFriction slows the player when running into walls
+// at steep angles.
+func (p *Player) wallFriction(trace *bsp.TraceResult, viewAngles vmath.Vector3) {
+	forward, _, _ := vmath.AngleVectors(viewAngles)
+	d := trace.Plane.Normal.Dot(forward) + 0.5
+	if d >= 0 {
+		return
+	}
+
+	// Cut the tangential velocity
+	into := trace.Plane.Normal.Scale(trace.Plane.Normal.Dot(p.Velocity))
+	side := p.Velocity.Sub(into)
+	p.Velocity.X = side.X * (1 + d)
+	p.Velocity.Y = side.Y * (1 + d)
+}
+
+// checkStuck tries to move the player out of solid space
+// if they have ended up there.
+func (p *Player) checkStuck(w World) {
+	if !p.inSolid(w) {
+		p.oldOrigin = p.Origin
+		return
+	}
+
+	org := p.Origin
+	p.Origin = p.oldOrigin
+	if !p.inSolid(w) {
+		return
+	}
+
+	for z := float32(0); z < stepSize; z++ {
+		for x := float32(-1); x <= 1; x++ {
+			for y := float32(-1); y <= 1; y++ {
+				p.Origin = org.Add(vmath.Vector3{X: x, Y: y, Z: z})
+				if !p.inSolid(w) {
+					return
+				}
+			}
+		}
+	}
+	p.Origin = org
+}
+
+func (p *Player) inSolid(w World) bool {
+	return w.Trace(p.Origin, p.Origin, bsp.HullPlayer).StartSolid
+}
+
+func abs(f float32) float32 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
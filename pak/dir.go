@@ -0,0 +1,266 @@
+package pak
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dir is a File backed by a directory of loose files.
+// Lookups are case-insensitive like the lookups into a
+// PAK file.
+type dir struct {
+	root string
+
+	mu    sync.Mutex
+	files map[string]*dirEntry
+	// Handles to replaced files that may still be in use
+	// by readers
+	old []*os.File
+}
+
+type dirEntry struct {
+	f       *os.File
+	size    int64
+	modTime time.Time
+}
+
+// FromDir creates a File that reads files from the
+// directory with the given name. Entry names are paths
+// relative to the directory using forward slashes.
+func FromDir(name string) (File, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+	return &dir{
+		root:  name,
+		files: make(map[string]*dirEntry),
+	}, nil
+}
+
+// Reader returns a section reader for the file with the
+// given name, returns nil if the file doesn't exist.
+// Files are reopened if they have changed since the last
+// call so edits are picked up without restarting.
+func (d *dir) Reader(name string) *io.SectionReader {
+	p, ok := d.resolve(name)
+	if !ok {
+		return nil
+	}
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := strings.ToLower(name)
+	e, ok := d.files[key]
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		return io.NewSectionReader(e.f, 0, e.size)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	if ok {
+		d.old = append(d.old, e.f)
+	}
+	e = &dirEntry{f: f, size: info.Size(), modTime: info.ModTime()}
+	d.files[key] = e
+	return io.NewSectionReader(e.f, 0, e.size)
+}
+
+// resolve finds the path of the named file matching each
+// part of the name case-insensitively.
+func (d *dir) resolve(name string) (string, bool) {
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		return "", false
+	}
+	// Fast path for exact matches
+	p := filepath.Join(d.root, filepath.FromSlash(name))
+	if _, err := os.Stat(p); err == nil {
+		return p, true
+	}
+
+	p = d.root
+	for _, part := range strings.Split(name, "/") {
+		infos, err := ioutil.ReadDir(p)
+		if err != nil {
+			return "", false
+		}
+		found := false
+		for _, info := range infos {
+			if strings.EqualFold(info.Name(), part) {
+				p = filepath.Join(p, info.Name())
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return p, true
+}
+
+// Close closes all files opened by the directory
+func (d *dir) Close() (err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.files {
+		if e := e.f.Close(); e != nil {
+			err = e
+		}
+	}
+	for _, f := range d.old {
+		if e := f.Close(); e != nil {
+			err = e
+		}
+	}
+	d.files = make(map[string]*dirEntry)
+	d.old = nil
+	return
+}
+
+// List returns every file in the directory, excluding the
+// directory's own pak files.
+func (d *dir) List() []Entry {
+	var entries []Entry
+	filepath.Walk(d.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, p)
+		if err != nil {
+			return nil
+		}
+		// The directory's pak files are searched separately
+		if pakName.MatchString(rel) {
+			return nil
+		}
+		entries = append(entries, Entry{
+			Name:   strings.ToLower(filepath.ToSlash(rel)),
+			Size:   info.Size(),
+			Source: d.root,
+		})
+		return nil
+	})
+	sort.Sort(entrySorter(entries))
+	return entries
+}
+
+// Stat returns information about the file with the given
+// name.
+func (d *dir) Stat(name string) (Entry, bool) {
+	p, ok := d.resolve(name)
+	if !ok {
+		return Entry{}, false
+	}
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() {
+		return Entry{}, false
+	}
+	return Entry{
+		Name:   strings.ToLower(path.Clean("/" + name)[1:]),
+		Size:   info.Size(),
+		Source: d.root,
+	}, true
+}
+
+type entrySorter []Entry
+
+func (e entrySorter) Len() int           { return len(e) }
+func (e entrySorter) Less(i, j int) bool { return e[i].Name < e[j].Name }
+func (e entrySorter) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+var pakName = regexp.MustCompile(`^(?i)pak([0-9]+)\.pak$`)
+
+// SearchPath creates a File for a game directory (e.g.
+// id1). The directory's pakN.pak files are searched
+// highest number first, so pak1.pak overrides pak0.pak.
+// Unlike Quake, loose files in the directory override all
+// of the pak files so that files can be changed without
+// repacking.
+func SearchPath(name string) (File, error) {
+	loose, err := FromDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(name)
+	if err != nil {
+		loose.Close()
+		return nil, err
+	}
+	type pakFile struct {
+		num  int
+		name string
+	}
+	var paks []pakFile
+	for _, info := range infos {
+		m := pakName.FindStringSubmatch(info.Name())
+		if m == nil || info.IsDir() {
+			continue
+		}
+		num, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		paks = append(paks, pakFile{num, info.Name()})
+	}
+	sort.Slice(paks, func(i, j int) bool {
+		return paks[i].num > paks[j].num
+	})
+
+	files := []File{loose}
+	for _, p := range paks {
+		f, err := FromFile(filepath.Join(name, p.name))
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return Join(files...), nil
+}
+
+// BaseGame is the directory containing the game's own
+// data.
+const BaseGame = "id1"
+
+// OpenGame creates a File for the game directory (e.g. a
+// mod like hipnotic) inside of the base directory, layered
+// over id1. The game's pak and loose files are searched
+// before id1's. An empty game only uses id1.
+func OpenGame(base, game string) (File, error) {
+	id1, err := SearchPath(filepath.Join(base, BaseGame))
+	if err != nil {
+		return nil, err
+	}
+	if game == "" || strings.EqualFold(game, BaseGame) {
+		return id1, nil
+	}
+
+	mod, err := SearchPath(filepath.Join(base, game))
+	if err != nil {
+		id1.Close()
+		return nil, err
+	}
+	return Join(mod, id1), nil
+}
@@ -2,12 +2,17 @@ package pak
 
 import (
 	"io"
+	"path"
+	"strings"
 )
 
 type pakGroup struct {
 	files []File
 }
 
+// Join combines the files into a single File. Entries are
+// searched for in the order the files are passed, so
+// earlier files override later ones.
 func Join(files ...File) File {
 	return pakGroup{files: files}
 }
@@ -22,6 +27,35 @@ func (g pakGroup) Reader(name string) *io.SectionReader {
 	return nil
 }
 
+// List returns every entry in the group. Where multiple
+// files contain an entry with the same name only the entry
+// from the earliest file, the one returned by Reader, is
+// listed.
+func (g pakGroup) List() []Entry {
+	var entries []Entry
+	seen := map[string]bool{}
+	for _, f := range g.files {
+		for _, e := range f.List() {
+			if !seen[e.Name] {
+				seen[e.Name] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries
+}
+
+// Stat returns information about the entry with the given
+// name from the earliest file that contains it.
+func (g pakGroup) Stat(name string) (Entry, bool) {
+	for _, f := range g.files {
+		if e, ok := f.Stat(name); ok {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
 func (g pakGroup) Close() (err error) {
 	for _, f := range g.files {
 		e := f.Close()
@@ -31,3 +65,22 @@ func (g pakGroup) Close() (err error) {
 	}
 	return
 }
+
+// Glob returns the entries in the file whose names match
+// the pattern, using the syntax of path.Match. Matching
+// is case-insensitive.
+func Glob(f File, pattern string) ([]Entry, error) {
+	pattern = strings.ToLower(pattern)
+	// Check the pattern is valid even if there are no
+	// entries
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	var matches []Entry
+	for _, e := range f.List() {
+		if ok, _ := path.Match(pattern, e.Name); ok {
+			matches = append(matches, e)
+		}
+	}
+	return matches, nil
+}
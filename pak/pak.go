@@ -4,9 +4,12 @@ package pak
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"github.com/thinkofdeath/goquake/internal/cstring"
 	"io"
 	"os"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -19,25 +22,71 @@ var (
 	ErrInvalid = errors.New("Invalid PAK file")
 )
 
+// File is a collection of named entries, e.g. a PAK file
+// or a directory.
 type File interface {
+	// Reader returns a section reader for the entry with
+	// the given name, returns nil if the entry doesn't exist.
 	Reader(name string) *io.SectionReader
+	// List returns every entry in the file.
+	List() []Entry
+	// Stat returns information about the entry with the
+	// given name.
+	Stat(name string) (Entry, bool)
 	Close() error
 }
 
+// Entry contains information about an entry in a File
+type Entry struct {
+	// Name is the entry's name in lower case
+	Name string
+	Size int64
+	// Source is the name of the archive or directory that
+	// supplies the entry.
+	Source string
+}
+
 // Type contains information about every entry in the PAK
 // file.
 type file struct {
+	name  string
 	r     readable
 	files map[string]pakEntry
+	// names of the entries in the order they are stored
+	names []string
 }
 
 // FromFile creates a pak.Type from a file with the given name
 func FromFile(name string) (t File, err error) {
+	t, _, err = openFile(name, false)
+	return
+}
+
+// FromFileLenient is like FromFile but skips invalid
+// entries instead of failing. The problems with the
+// skipped entries are returned. Problems with the header
+// or directory are still returned as an error.
+func FromFileLenient(name string) (t File, skipped []*FormatError, err error) {
+	return openFile(name, true)
+}
+
+func openFile(name string, lenient bool) (t File, skipped []*FormatError, err error) {
 	file, err := os.Open(name)
 	if err != nil {
 		return
 	}
-	t, err = fromReadable(file)
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	f, skipped, err := fromReadable(file, info.Size(), lenient)
+	if err != nil {
+		file.Close()
+		return
+	}
+	f.name = name
+	t = f
 	return
 }
 
@@ -46,7 +95,23 @@ type readable interface {
 	io.ReaderAt
 }
 
-func fromReadable(r readable) (t *file, err error) {
+// FormatError is returned when part of the PAK file is
+// invalid, Entry is empty for problems with the directory.
+type FormatError struct {
+	Entry  string
+	Offset int64
+	Size   int64
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	if e.Entry == "" {
+		return fmt.Sprintf("pak: %s (offset %d, size %d)", e.Reason, e.Offset, e.Size)
+	}
+	return fmt.Sprintf("pak: %s: %s (offset %d, size %d)", e.Entry, e.Reason, e.Offset, e.Size)
+}
+
+func fromReadable(r readable, size int64, lenient bool) (t *file, skipped []*FormatError, err error) {
 	var header header
 	err = binary.Read(r, binary.LittleEndian, &header)
 	if err != nil {
@@ -60,15 +125,19 @@ func fromReadable(r readable) (t *file, err error) {
 		return
 	}
 
-	t = &file{
-		r:     r,
-		files: make(map[string]pakEntry),
+	dirOffset, dirSize := int64(header.DirOffset), int64(header.DirSize)
+	if dirOffset < 0 || dirSize < 0 || dirOffset+dirSize > size {
+		err = &FormatError{Offset: dirOffset, Size: dirSize, Reason: "directory out of bounds"}
+		return
+	}
+	if dirSize%entrySize != 0 {
+		err = &FormatError{Offset: dirOffset, Size: dirSize, Reason: "directory size isn't a multiple of the entry size"}
+		return
 	}
 
-	entries := make([]entry, header.DirSize/entrySize)
-
+	entries := make([]entry, dirSize/entrySize)
 	err = binary.Read(
-		io.NewSectionReader(r, int64(header.DirOffset), int64(header.DirSize)),
+		io.NewSectionReader(r, dirOffset, dirSize),
 		binary.LittleEndian,
 		entries,
 	)
@@ -76,13 +145,70 @@ func fromReadable(r readable) (t *file, err error) {
 		return
 	}
 
+	t = &file{
+		r:     r,
+		files: make(map[string]pakEntry),
+	}
 	for _, e := range entries {
-		name := strings.ToLower(fromCString(e.FileName[:]))
-		t.files[name] = pakEntry{int64(e.Offset), int64(e.Size)}
+		name := cstring.String(e.FileName[:])
+		offset, length := int64(e.Offset), int64(e.Size)
+
+		var reason string
+		lower := strings.ToLower(name)
+		_, duplicate := t.files[lower]
+		switch {
+		case name == "":
+			reason = "empty name"
+		case !utf8.ValidString(name):
+			reason = "name isn't valid UTF-8"
+		case offset < 0 || length < 0 || offset+length > size:
+			reason = "entry out of bounds"
+		case duplicate:
+			reason = "duplicate entry"
+		}
+		if reason != "" {
+			ferr := &FormatError{
+				Entry:  name,
+				Offset: offset,
+				Size:   length,
+				Reason: reason,
+			}
+			if !lenient {
+				t = nil
+				err = ferr
+				return
+			}
+			skipped = append(skipped, ferr)
+			continue
+		}
+
+		t.names = append(t.names, lower)
+		t.files[lower] = pakEntry{offset, length}
 	}
 	return
 }
 
+// List returns every entry in the PAK file in the order
+// they are stored.
+func (t *file) List() []Entry {
+	entries := make([]Entry, len(t.names))
+	for i, name := range t.names {
+		entries[i], _ = t.Stat(name)
+	}
+	return entries
+}
+
+// Stat returns information about the entry with the given
+// name.
+func (t *file) Stat(name string) (Entry, bool) {
+	name = strings.ToLower(name)
+	e, ok := t.files[name]
+	if !ok {
+		return Entry{}, false
+	}
+	return Entry{Name: name, Size: e.Size, Source: t.name}, true
+}
+
 // Reader returns a section reader for the entry with
 // the given name, returns nil if the entry doesn't exist
 // in this PAK file. Readers returned from this will be
@@ -108,16 +234,6 @@ type pakEntry struct {
 
 // Parsing helpers
 
-// Trims the string to the first 0 byte
-func fromCString(b []byte) string {
-	for i := 0; i < len(b); i++ {
-		if b[i] == 0 {
-			return string(b[:i])
-		}
-	}
-	return string(b)
-}
-
 type header struct {
 	Magic     [4]byte
 	DirOffset int32
@@ -0,0 +1,210 @@
+package pak
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/thinkofdeath/goquake/internal/cstring"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	wadMagic     = "WAD2"
+	wadEntrySize = 0x20
+)
+
+var (
+	// ErrInvalidWAD is returned when the WAD file is invalid
+	ErrInvalidWAD = errors.New("Invalid WAD file")
+)
+
+// LumpType is the type of data stored in a lump
+type LumpType uint8
+
+// Types of lumps that can be stored in a WAD file
+const (
+	LumpNone    LumpType = 0
+	LumpLabel   LumpType = 1
+	LumpPalette LumpType = '@'
+	LumpQTex    LumpType = 'A'
+	LumpQPic    LumpType = 'B'
+	LumpSound   LumpType = 'C'
+	LumpMipTex  LumpType = 'D'
+)
+
+// Lump contains information about an entry in a WAD file
+type Lump struct {
+	Name string
+	Type LumpType
+	Size int64
+
+	offset int64
+}
+
+// WAD contains information about every lump in a WAD2
+// file. Lumps are accessed by name, like entries in a PAK
+// file, allowing a WAD to be joined with other files.
+type WAD struct {
+	name  string
+	r     readable
+	lumps []Lump
+	names map[string]int
+}
+
+// WADFromFile reads a WAD2 file with the given name
+func WADFromFile(name string) (w *WAD, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	w, err = wadFromReadable(file, info.Size())
+	if err != nil {
+		file.Close()
+		return
+	}
+	w.name = name
+	return
+}
+
+// WADFromReader reads a WAD2 file of the given size from
+// the reader, this is normally used with a reader from a
+// PAK file (e.g. for gfx.wad). Closing the returned WAD
+// doesn't close the reader.
+func WADFromReader(r io.ReaderAt, size int64) (*WAD, error) {
+	return wadFromReadable(nopCloser{io.NewSectionReader(r, 0, size)}, size)
+}
+
+func wadFromReadable(r readable, size int64) (w *WAD, err error) {
+	var header wadHeader
+	err = binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return
+	}
+
+	if string(header.Magic[:]) != wadMagic {
+		err = ErrInvalidWAD
+		return
+	}
+	if header.NumLumps < 0 || header.InfoOffset < 0 || int64(header.InfoOffset) > size ||
+		int64(header.NumLumps) > (size-int64(header.InfoOffset))/wadEntrySize {
+		err = ErrInvalidWAD
+		return
+	}
+
+	entries := make([]wadEntry, header.NumLumps)
+	err = binary.Read(
+		io.NewSectionReader(r, int64(header.InfoOffset), int64(header.NumLumps)*wadEntrySize),
+		binary.LittleEndian,
+		entries,
+	)
+	if err != nil {
+		return
+	}
+
+	w = &WAD{
+		r:     r,
+		lumps: make([]Lump, 0, len(entries)),
+		names: make(map[string]int),
+	}
+	for _, e := range entries {
+		if e.Compression != 0 {
+			// Quake never supported compressed lumps
+			w = nil
+			err = errors.New("compressed lumps are unsupported")
+			return
+		}
+		if e.Offset < 0 || e.Size < 0 || int64(e.Offset)+int64(e.Size) > size {
+			w = nil
+			err = ErrInvalidWAD
+			return
+		}
+		name := strings.ToLower(cstring.String(e.Name[:]))
+		w.names[name] = len(w.lumps)
+		w.lumps = append(w.lumps, Lump{
+			Name:   name,
+			Type:   LumpType(e.Type),
+			Size:   int64(e.Size),
+			offset: int64(e.Offset),
+		})
+	}
+	return
+}
+
+// Lumps returns every lump in the file in the order they
+// are stored.
+func (w *WAD) Lumps() []Lump {
+	return w.lumps
+}
+
+// Lump returns information about the lump with the given
+// name.
+func (w *WAD) Lump(name string) (Lump, bool) {
+	i, ok := w.names[strings.ToLower(name)]
+	if !ok {
+		return Lump{}, false
+	}
+	return w.lumps[i], true
+}
+
+// Reader returns a section reader for the lump with the
+// given name, returns nil if the lump doesn't exist in
+// this WAD file.
+func (w *WAD) Reader(name string) *io.SectionReader {
+	l, ok := w.Lump(name)
+	if !ok {
+		return nil
+	}
+	return io.NewSectionReader(w.r, l.offset, l.Size)
+}
+
+// List returns every lump in the file
+func (w *WAD) List() []Entry {
+	entries := make([]Entry, len(w.lumps))
+	for i, l := range w.lumps {
+		entries[i] = Entry{Name: l.Name, Size: l.Size, Source: w.name}
+	}
+	return entries
+}
+
+// Stat returns information about the lump with the given
+// name.
+func (w *WAD) Stat(name string) (Entry, bool) {
+	l, ok := w.Lump(name)
+	if !ok {
+		return Entry{}, false
+	}
+	return Entry{Name: l.Name, Size: l.Size, Source: w.name}, true
+}
+
+// Close closes the reader used for the WAD file
+func (w *WAD) Close() error {
+	return w.r.Close()
+}
+
+type nopCloser struct {
+	*io.SectionReader
+}
+
+func (nopCloser) Close() error { return nil }
+
+type wadHeader struct {
+	Magic      [4]byte
+	NumLumps   int32
+	InfoOffset int32
+}
+
+type wadEntry struct {
+	Offset      int32
+	DiskSize    int32
+	Size        int32
+	Type        uint8
+	Compression uint8
+	_           uint16
+	Name        [16]byte
+}
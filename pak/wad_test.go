@@ -0,0 +1,59 @@
+package pak
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// buildTestWAD creates a WAD containing a single lump
+// with the directory after it.
+func buildTestWAD() []byte {
+	var b bytes.Buffer
+	header := wadHeader{NumLumps: 1, InfoOffset: 16}
+	copy(header.Magic[:], wadMagic)
+	binary.Write(&b, binary.LittleEndian, header)
+	b.Write([]byte{1, 2, 3, 4})
+	e := wadEntry{Offset: 12, DiskSize: 4, Size: 4, Type: byte(LumpQPic)}
+	copy(e.Name[:], "CONCHARS")
+	binary.Write(&b, binary.LittleEndian, e)
+	return b.Bytes()
+}
+
+func TestWADFromReader(t *testing.T) {
+	data := buildTestWAD()
+	w, err := WADFromReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, ok := w.Lump("conchars")
+	if !ok || l.Type != LumpQPic || l.Size != 4 {
+		t.Fatalf("unexpected lump %+v", l)
+	}
+	d, err := ioutil.ReadAll(w.Reader("ConChars"))
+	if err != nil || !bytes.Equal(d, []byte{1, 2, 3, 4}) {
+		t.Errorf("unexpected contents %v %v", d, err)
+	}
+}
+
+func TestWADBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+		value  int32
+	}{
+		{"lump count", 4, 1 << 30},
+		{"directory offset", 8, 1 << 30},
+		{"lump offset", 16, 46},
+		{"lump size", 16 + 8, 100},
+		{"negative lump size", 16 + 8, -1},
+	}
+	for _, test := range tests {
+		data := buildTestWAD()
+		binary.LittleEndian.PutUint32(data[test.offset:], uint32(test.value))
+		if _, err := WADFromReader(bytes.NewReader(data), int64(len(data))); err != ErrInvalidWAD {
+			t.Errorf("%s: expected ErrInvalidWAD, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package pak
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNameTooLong is returned when an entry's name doesn't
+	// fit in the 56 bytes (including the terminating 0)
+	// allowed by the format.
+	ErrNameTooLong = errors.New("entry name too long")
+	// ErrDuplicate is returned when adding an entry with the
+	// same name as an existing entry.
+	ErrDuplicate = errors.New("duplicate entry")
+	// ErrClosed is returned when using a closed Writer
+	ErrClosed = errors.New("writer closed")
+	// ErrTooLarge is returned when the file would be too
+	// large for the 32 bit offsets used by the format.
+	ErrTooLarge = errors.New("file too large")
+)
+
+const headerSize = 12
+
+// Writer creates a PAK file. Entries are written one
+// after another followed by the directory when the writer
+// is closed.
+type Writer struct {
+	w       io.WriteSeeker
+	offset  int64
+	entries []entry
+	names   map[string]bool
+	current *entryWriter
+	closed  bool
+}
+
+// NewWriter creates a Writer that writes a PAK file to w.
+// w should be at the start of an empty file.
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	// Space for the header, this is filled in by Close once
+	// the location of the directory is known
+	_, err := w.Write(make([]byte, headerSize))
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:      w,
+		offset: headerSize,
+		names:  make(map[string]bool),
+	}, nil
+}
+
+// Create adds an entry with the given name to the file
+// and returns a writer for its contents. The entry ends
+// when Create, Add or Close is next called.
+func (pw *Writer) Create(name string) (io.Writer, error) {
+	if pw.closed {
+		return nil, ErrClosed
+	}
+	if len(name) >= len(entry{}.FileName) {
+		return nil, ErrNameTooLong
+	}
+	lower := strings.ToLower(name)
+	if pw.names[lower] {
+		return nil, ErrDuplicate
+	}
+	pw.finishEntry()
+	pw.names[lower] = true
+
+	e := entry{Offset: int32(pw.offset)}
+	copy(e.FileName[:], name)
+	pw.entries = append(pw.entries, e)
+	pw.current = &entryWriter{pw: pw}
+	return pw.current, nil
+}
+
+// Add adds an entry with the given name containing the
+// contents of r.
+func (pw *Writer) Add(name string, r io.Reader) error {
+	w, err := pw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (pw *Writer) finishEntry() {
+	if pw.current == nil {
+		return
+	}
+	e := &pw.entries[len(pw.entries)-1]
+	e.Size = int32(pw.offset - int64(e.Offset))
+	pw.current.pw = nil
+	pw.current = nil
+}
+
+// Close writes the directory of the PAK file. This does
+// not close the underlying writer.
+func (pw *Writer) Close() error {
+	if pw.closed {
+		return ErrClosed
+	}
+	pw.finishEntry()
+	pw.closed = true
+
+	dirSize := int64(len(pw.entries)) * entrySize
+	if pw.offset+dirSize > math.MaxInt32 {
+		return ErrTooLarge
+	}
+	err := binary.Write(pw.w, binary.LittleEndian, pw.entries)
+	if err != nil {
+		return err
+	}
+	end, err := pw.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = pw.w.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	h := header{
+		DirOffset: int32(pw.offset),
+		DirSize:   int32(dirSize),
+	}
+	copy(h.Magic[:], pakMagic)
+	err = binary.Write(pw.w, binary.LittleEndian, h)
+	if err != nil {
+		return err
+	}
+	_, err = pw.w.Seek(end, io.SeekStart)
+	return err
+}
+
+type entryWriter struct {
+	pw *Writer
+}
+
+func (e *entryWriter) Write(b []byte) (int, error) {
+	if e.pw == nil {
+		return 0, ErrClosed
+	}
+	// Keeps every offset and size in the directory within
+	// an int32
+	if e.pw.offset+int64(len(b)) > math.MaxInt32 {
+		return 0, ErrTooLarge
+	}
+	n, err := e.pw.w.Write(b)
+	e.pw.offset += int64(n)
+	return n, err
+}
+
+// Rewrite writes a new PAK file to w containing every
+// entry in src, in the order returned by src.List, with
+// the passed replacements. Replacements are matched to
+// entries ignoring case. A nil replacement removes the
+// entry and replacements for entries that don't exist in
+// src are added to the end of the file.
+func Rewrite(w io.WriteSeeker, src File, replace map[string]io.Reader) error {
+	pw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	lowerReplace := make(map[string]io.Reader, len(replace))
+	for name, r := range replace {
+		lowerReplace[strings.ToLower(name)] = r
+	}
+
+	used := map[string]bool{}
+	for _, e := range src.List() {
+		used[e.Name] = true
+		r, ok := lowerReplace[e.Name]
+		if !ok {
+			r = src.Reader(e.Name)
+		} else if r == nil {
+			continue
+		}
+		if err := pw.Add(e.Name, r); err != nil {
+			return fmt.Errorf("%s: %s", e.Name, err)
+		}
+	}
+
+	// Sorted so the output doesn't depend on the map's
+	// order
+	var added []string
+	for name, r := range replace {
+		if r != nil && !used[strings.ToLower(name)] {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		if err := pw.Add(name, replace[name]); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return pw.Close()
+}
@@ -0,0 +1,139 @@
+package pak
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// tempFile creates a file that is removed at the end of
+// the test.
+func tempFile(t *testing.T) *os.File {
+	f, err := ioutil.TempFile("", "pak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	return f
+}
+
+// writeTestPak writes a PAK file containing the entries,
+// in order, and opens it.
+func writeTestPak(t *testing.T, entries [][2]string) File {
+	f := tempFile(t)
+	w, err := NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := w.Add(e[0], strings.NewReader(e[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return openTestPak(t, f.Name())
+}
+
+func openTestPak(t *testing.T, name string) File {
+	p, err := FromFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// checkEntries checks that the file contains exactly the
+// entries, in order.
+func checkEntries(t *testing.T, p File, entries [][2]string) {
+	list := p.List()
+	if len(list) != len(entries) {
+		t.Fatalf("expected %d entries, got %+v", len(entries), list)
+	}
+	for i, e := range entries {
+		if list[i].Name != e[0] || list[i].Size != int64(len(e[1])) {
+			t.Errorf("entry %d: expected %s, got %+v", i, e[0], list[i])
+		}
+		data, err := ioutil.ReadAll(p.Reader(e[0]))
+		if err != nil || string(data) != e[1] {
+			t.Errorf("%s: unexpected contents %q %v", e[0], data, err)
+		}
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	entries := [][2]string{
+		{"maps/start.bsp", "level"},
+		{"gfx.wad", "pictures"},
+		{"empty.txt", ""},
+		{"progs/player.mdl", "model"},
+	}
+	checkEntries(t, writeTestPak(t, entries), entries)
+}
+
+func TestWriterErrors(t *testing.T) {
+	w, err := NewWriter(tempFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add("a.txt", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add("A.TXT", strings.NewReader("b")); err != ErrDuplicate {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+	if err := w.Add(strings.Repeat("a", 56), strings.NewReader("")); err != ErrNameTooLong {
+		t.Errorf("expected ErrNameTooLong, got %v", err)
+	}
+
+	// Pretend the file is close to the limit rather than
+	// writing 2GiB
+	e, err := w.Create("large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.offset = math.MaxInt32 - 1
+	if _, err := e.Write([]byte{1, 2}); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge writing, got %v", err)
+	}
+	if err := w.Close(); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge closing, got %v", err)
+	}
+	if _, err := e.Write([]byte{1}); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	src := writeTestPak(t, [][2]string{
+		{"a.txt", "a"},
+		{"b.txt", "b"},
+		{"c.txt", "c"},
+	})
+	f := tempFile(t)
+	err := Rewrite(f, src, map[string]io.Reader{
+		"B.TXT": nil,
+		"c.txt": strings.NewReader("new c"),
+		"e.txt": strings.NewReader("e"),
+		"d.txt": strings.NewReader("d"),
+		"x.txt": nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, openTestPak(t, f.Name()), [][2]string{
+		{"a.txt", "a"},
+		{"c.txt", "new c"},
+		// Added entries are sorted
+		{"d.txt", "d"},
+		{"e.txt", "e"},
+	})
+}
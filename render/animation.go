@@ -0,0 +1,98 @@
+package render
+
+import (
+	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/render/atlas"
+	"time"
+)
+
+// Rate that animated textures change frame, this matches
+// Quake
+const textureFrameRate = 5
+
+// Maximum number of animated textures in a level, this
+// must match the size of animOffsets in the main shader.
+// Slot 0 isn't used so that 0 can mean not animated.
+const maxAnimations = 64
+
+// textureAnimation is an animated texture used by the
+// faces of a model. Each animation has a slot in the main
+// shader's animOffsets which contains the atlas position of
+// its current frame.
+type textureAnimation struct {
+	model     int
+	frames    []*atlas.Rect
+	alternate []*atlas.Rect
+}
+
+type animationKey struct {
+	model int
+	// The first frame of the animation
+	texture *bsp.Texture
+}
+
+// animationSlot returns the slot of the animation for the
+// texture on the model's faces, 0 if the texture isn't
+// animated. Faces of each model get their own slot so that
+// the model can be switched to the alternate frames
+// separately.
+func (m *qMap) animationSlot(model int, t *bsp.Texture) uint8 {
+	if t.AnimFrames == nil {
+		return 0
+	}
+	key := animationKey{model, t.AnimFrames[0]}
+	if slot, ok := m.animationSlots[key]; ok {
+		return slot
+	}
+
+	base := m.textures[t.ID]
+	rects := func(frames []*bsp.Texture) ([]*atlas.Rect, bool) {
+		var out []*atlas.Rect
+		for _, f := range frames {
+			r := m.textures[f.ID]
+			// The frames replace the texture's position in the
+			// atlas so must be the same size
+			if r.Width != base.Width || r.Height != base.Height {
+				return nil, false
+			}
+			out = append(out, r)
+		}
+		return out, true
+	}
+	frames, ok := rects(t.AnimFrames)
+	alternate, altOK := rects(t.AlternateFrames)
+	var slot uint8
+	if ok && altOK && len(m.animations)+1 < maxAnimations {
+		m.animations = append(m.animations, &textureAnimation{
+			model:     model,
+			frames:    frames,
+			alternate: alternate,
+		})
+		slot = uint8(len(m.animations))
+	}
+	m.animationSlots[key] = slot
+	return slot
+}
+
+// updateAnimations sets the current frame of every
+// animation.
+func (m *qMap) updateAnimations() {
+	frame := int(time.Now().UnixNano() / int64(time.Second/textureFrameRate))
+	for i, a := range m.animations {
+		frames := a.frames
+		if m.alternateModels[a.model] && a.alternate != nil {
+			frames = a.alternate
+		}
+		r := frames[frame%len(frames)]
+		m.animOffsets[(i+1)*2] = float32(r.X)
+		m.animOffsets[(i+1)*2+1] = float32(r.Y)
+	}
+}
+
+// SetAlternateTextures switches the animated textures of
+// the brush model to their alternate frames, e.g. for a
+// pressed button. model is the index used by an entity's
+// model key ("*N"), 0 being the world.
+func SetAlternateTextures(model int, alternate bool) {
+	currentMap.alternateModels[model] = alternate
+}
@@ -2,10 +2,15 @@
 package atlas
 
 import (
+	"errors"
 	"github.com/thinkofdeath/goquake/bsp"
 	"math"
 )
 
+// ErrAtlasFull is returned when there isn't enough free
+// space in the atlas for a picture
+var ErrAtlasFull = errors.New("atlas full")
+
 // Type is a texture atlas for storing quake
 // pictures (from the bsp package). The buffer
 // is public to allow easy uploading to the
@@ -53,10 +58,10 @@ func NewPadded(width, height int, padding int) *Type {
 }
 
 // Add adds the passed picture to the atlas and
-// returns the location in the atlas. This method
-// panics if the atlas has been baked or if the
-// atlas is full.
-func (a *Type) Add(picture *bsp.Picture) *Rect {
+// returns the location in the atlas. ErrAtlasFull
+// is returned if the picture doesn't fit. This
+// method panics if the atlas has been baked.
+func (a *Type) Add(picture *bsp.Picture) (*Rect, error) {
 	if a.baked {
 		panic("invalid state, atlas is baked")
 	}
@@ -87,10 +92,7 @@ func (a *Type) Add(picture *bsp.Picture) *Rect {
 	}
 
 	if target == nil {
-		// TODO(Think) Return an error here and
-		//             add a 'MustAdd' for the
-		//             cases where you don't care?
-		panic("atlas full")
+		return nil, ErrAtlasFull
 	}
 
 	// Copy the picture into the atlas
@@ -126,7 +128,7 @@ func (a *Type) Add(picture *bsp.Picture) *Rect {
 		Y:      ty,
 		Width:  picture.Width,
 		Height: picture.Height,
-	}
+	}, nil
 }
 
 // Bake causes the atlas to be uneditable allowing
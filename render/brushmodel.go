@@ -0,0 +1,78 @@
+package render
+
+import (
+	"errors"
+	"github.com/thinkofdeath/goquake/render/gl"
+	"github.com/thinkofdeath/goquake/vmath"
+	"math"
+)
+
+var identityMatrix = vmath.NewMatrix4()
+
+// ErrInvalidModel is returned when moving a brush model
+// that doesn't exist
+var ErrInvalidModel = errors.New("invalid model")
+
+// brushModel is one of the level's bsp models, the world
+// being the first. The faces of each model are stored
+// together in the map's buffers so that the model can be
+// drawn with its own transform.
+type brushModel struct {
+	normal drawRange
+	sky    drawRange
+	matrix *vmath.Matrix4
+}
+
+func newBrushModel(origin vmath.Vector3) *brushModel {
+	bm := &brushModel{matrix: vmath.NewMatrix4()}
+	bm.matrix.Translate(origin.X, origin.Y, origin.Z)
+	return bm
+}
+
+// SetModelTransform moves the brush model, e.g. for an
+// opening door or a moving lift. origin is the new
+// position of the model's origin and angles is its pitch,
+// yaw and roll in degrees. model is the index used by an
+// entity's model key ("*N"), the world (0) can't be moved.
+func SetModelTransform(model int, origin, angles vmath.Vector3) error {
+	m := currentMap
+	if model <= 0 || model >= len(m.brushModels) {
+		return ErrInvalidModel
+	}
+	const toRadians = math.Pi / 180
+	mat := m.brushModels[model].matrix
+	mat.Identity()
+	mat.RotateX(-angles.Z * toRadians)
+	mat.RotateY(-angles.X * toRadians)
+	mat.RotateZ(-angles.Y * toRadians)
+	mat.Translate(origin.X, origin.Y, origin.Z)
+	return nil
+}
+
+// drawModels draws the ranges of the world that are
+// visible followed by the surfaces of that kind of every
+// other brush model, each with its own transform.
+func (m *qMap) drawModels(matrix gl.Uniform, world []drawRange, kind surfaceKind) {
+	matrix.Matrix4(false, m.brushModels[0].matrix)
+	drawRanges(world)
+	for _, bm := range m.brushModels[1:] {
+		r := bm.normal
+		if kind == surfaceSky {
+			r = bm.sky
+		}
+		if r.count == 0 {
+			continue
+		}
+		matrix.Matrix4(false, bm.matrix)
+		gl.DrawArrays(gl.Triangles, r.offset, r.count)
+	}
+}
+
+// transformPoint returns the point moved by the matrix
+func transformPoint(mat *vmath.Matrix4, p vmath.Vector3) vmath.Vector3 {
+	return vmath.Vector3{
+		X: p.X*mat[0] + p.Y*mat[4] + p.Z*mat[8] + mat[12],
+		Y: p.X*mat[1] + p.Y*mat[5] + p.Z*mat[9] + mat[13],
+		Z: p.X*mat[2] + p.Y*mat[6] + p.Z*mat[10] + mat[14],
+	}
+}
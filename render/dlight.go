@@ -0,0 +1,109 @@
+package render
+
+import (
+	"github.com/thinkofdeath/goquake/vmath"
+	"time"
+)
+
+// Maximum number of dynamic lights at once, this must
+// match the size of the arrays in the main shader
+const maxDynamicLights = 8
+
+// DynamicLight is a short lived point light, e.g. a muzzle
+// flash or an explosion. Its brightness falls off linearly
+// from the origin to the edge of its radius.
+type DynamicLight struct {
+	Origin vmath.Vector3
+	Radius float32
+	// Colour tints the light, 1 for each component is
+	// white. Levels without coloured lighting only use the
+	// brightness.
+	Colour vmath.Vector3
+	// Decay is how much the radius shrinks per second
+	Decay float32
+	// Duration is how long the light lasts. A zero duration
+	// lasts until the radius has decayed to nothing.
+	Duration time.Duration
+}
+
+type dynamicLight struct {
+	DynamicLight
+	die time.Time
+}
+
+// AddDynamicLight adds a light to the current level. When
+// there are already too many lights the one closest to
+// fading out is replaced. e.g. Quake's explosion light in
+// front of the player:
+//
+//	render.AddDynamicLight(render.DynamicLight{
+//		Origin:   render.ViewPoint(64),
+//		Radius:   350,
+//		Colour:   vmath.Vector3{X: 1, Y: 1, Z: 1},
+//		Decay:    300,
+//		Duration: 500 * time.Millisecond,
+//	})
+func AddDynamicLight(l DynamicLight) {
+	dl := &dynamicLight{DynamicLight: l}
+	if l.Duration > 0 {
+		dl.die = time.Now().Add(l.Duration)
+	}
+	m := currentMap
+	if len(m.dynamicLights) < maxDynamicLights {
+		m.dynamicLights = append(m.dynamicLights, dl)
+		return
+	}
+	weakest := 0
+	for i, o := range m.dynamicLights {
+		if o.Radius < m.dynamicLights[weakest].Radius {
+			weakest = i
+		}
+	}
+	m.dynamicLights[weakest] = dl
+}
+
+// updateDynamicLights decays the lights, removes the ones
+// that have faded out and fills the arrays passed to the
+// main shader.
+func (m *qMap) updateDynamicLights() {
+	now := time.Now()
+	dt := float32(now.Sub(m.lastLightUpdate).Seconds())
+	m.lastLightUpdate = now
+
+	alive := m.dynamicLights[:0]
+	for _, l := range m.dynamicLights {
+		l.Radius -= l.Decay * dt
+		if l.Radius <= 0 || (!l.die.IsZero() && now.After(l.die)) {
+			continue
+		}
+		alive = append(alive, l)
+	}
+	for i := len(alive); i < len(m.dynamicLights); i++ {
+		m.dynamicLights[i] = nil
+	}
+	m.dynamicLights = alive
+
+	for i, l := range m.dynamicLights {
+		m.lightPositions[i*4] = l.Origin.X
+		m.lightPositions[i*4+1] = l.Origin.Y
+		m.lightPositions[i*4+2] = l.Origin.Z
+		m.lightPositions[i*4+3] = l.Radius
+		m.lightColours[i*3] = l.Colour.X
+		m.lightColours[i*3+1] = l.Colour.Y
+		m.lightColours[i*3+2] = l.Colour.Z
+	}
+}
+
+// dynamicLightAt returns the amount the dynamic lights
+// brighten the point, in the same units as the light
+// maps (1 being full brightness).
+func (m *qMap) dynamicLightAt(p vmath.Vector3) float32 {
+	var amount float32
+	for _, l := range m.dynamicLights {
+		d := l.Radius - l.Origin.Sub(p).Length()
+		if d > 0 {
+			amount += d / 255 * (l.Colour.X + l.Colour.Y + l.Colour.Z) / 3
+		}
+	}
+	return amount
+}
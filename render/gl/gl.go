@@ -14,6 +14,7 @@ const (
 	DepthTest    Flag = gl.DEPTH_TEST
 	CullFaceFlag Flag = gl.CULL_FACE
 	StencilTest  Flag = gl.STENCIL_TEST
+	Blend        Flag = gl.BLEND
 
 	Back  Face = gl.BACK
 	Front Face = gl.FRONT
@@ -33,6 +34,10 @@ const (
 	Replace Op = gl.REPLACE
 	Keep    Op = gl.KEEP
 	Zero    Op = gl.ZERO
+
+	One              BlendFactor = gl.ONE
+	SrcAlpha         BlendFactor = gl.SRC_ALPHA
+	OneMinusSrcAlpha BlendFactor = gl.ONE_MINUS_SRC_ALPHA
 )
 
 func Init() {
@@ -49,6 +54,7 @@ type (
 	DrawType      uint32
 	Func          uint32
 	Op            uint32
+	BlendFactor   uint32
 )
 
 func Viewport(x, y, width, height int) {
@@ -98,6 +104,10 @@ func Flush() {
 	gl.Flush()
 }
 
+func BlendFunc(src, dst BlendFactor) {
+	gl.BlendFunc(uint32(src), uint32(dst))
+}
+
 func DepthMask(f bool) {
 	gl.DepthMask(f)
 }
@@ -118,3 +118,28 @@ func (s Shader) InfoLog() string {
 	gl.GetShaderInfoLog(uint32(s), int32(l), nil, (*uint8)(gl.Ptr(buf)))
 	return string(buf)
 }
+
+func (u Uniform) Float2(x, y float32) {
+	gl.Uniform2f(int32(u), x, y)
+}
+
+// FloatArray sets the uniform to an array of floats
+func (u Uniform) FloatArray(vals []float32) {
+	gl.Uniform1fv(int32(u), int32(len(vals)), &vals[0])
+}
+
+// Float3Array sets the uniform to an array of vec3s
+func (u Uniform) Float3Array(vals []float32) {
+	gl.Uniform3fv(int32(u), int32(len(vals)/3), &vals[0])
+}
+
+// Float4Array sets the uniform to an array of vec4s
+func (u Uniform) Float4Array(vals []float32) {
+	gl.Uniform4fv(int32(u), int32(len(vals)/4), &vals[0])
+}
+
+// Float2Array sets the uniform to an array of vec2s. vals
+// contains the x and y of each element.
+func (u Uniform) Float2Array(vals []float32) {
+	gl.Uniform2fv(int32(u), int32(len(vals)/2), &vals[0])
+}
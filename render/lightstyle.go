@@ -0,0 +1,102 @@
+package render
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate that light styles change brightness, this matches
+// Quake
+const lightStyleRate = 10
+
+// Number of light styles, this must match the size of
+// lightStyles in the main shader
+const maxLightStyles = 64
+
+// First light style used for lights that can be switched
+// on and off
+const firstSwitchableStyle = 32
+
+// The light styles set by Quake's worldspawn. Each letter
+// is the brightness for a tenth of a second, a being dark,
+// m normal and z double brightness.
+var defaultLightStyles = map[int]string{
+	0:  "m",
+	1:  "mmnmmommommnonmmonqnmmo",
+	2:  "abcdefghijklmnopqrstuvwxyzyxwvutsrqponmlkjihgfedcba",
+	3:  "mmmmmaaaaammmmmaaaaaabcdefgabcdefg",
+	4:  "mamamamamama",
+	5:  "jklmnopqrstuvwxyzyxwvutsrqponmlkj",
+	6:  "nmonqnmomnmomomno",
+	7:  "mmmaaaabcdefgmmmmaaaammmaamm",
+	8:  "mmmaaammmaaammmabcdefaaaammmmabcdefmmmaaaa",
+	9:  "aaaaaaaazzzzzzzz",
+	10: "mmamammmmammamamaaamammma",
+	11: "abcdefghijklmnopqrrqponmlkjihgfedcba",
+	63: "a",
+}
+
+// ErrInvalidLightStyle is returned when setting a light
+// style that doesn't exist
+var ErrInvalidLightStyle = errors.New("invalid light style")
+
+// initLightStyles sets the level's light styles to Quake's
+// defaults. Switchable lights are on unless the light
+// entity starts off.
+func (m *qMap) initLightStyles() {
+	m.lightStyleValues = make([]float32, maxLightStyles)
+	for style := range m.lightStyles {
+		m.lightStyles[style] = "m"
+	}
+	for style, pattern := range defaultLightStyles {
+		m.lightStyles[style] = pattern
+	}
+	for _, e := range m.bsp.Entities {
+		if !strings.HasPrefix(e.ClassName(), "light") {
+			continue
+		}
+		style, err := strconv.Atoi(e.Value("style"))
+		if err != nil || style < firstSwitchableStyle || style >= maxLightStyles {
+			continue
+		}
+		// Spawn flag 1 is START_OFF
+		flags, _ := strconv.Atoi(e.Value("spawnflags"))
+		if flags&1 != 0 {
+			m.lightStyles[style] = "a"
+		}
+	}
+}
+
+// updateLightStyles sets the current brightness of every
+// light style.
+func (m *qMap) updateLightStyles() {
+	frame := int(time.Now().UnixNano() / int64(time.Second/lightStyleRate))
+	for i, pattern := range m.lightStyles {
+		if pattern == "" {
+			// Like Quake an empty style is normal brightness
+			m.lightStyleValues[i] = 1
+			continue
+		}
+		// Quake scales 'm' to 264/256, just over normal
+		// brightness
+		v := int(pattern[frame%len(pattern)]) - 'a'
+		if v < 0 {
+			v = 0
+		}
+		m.lightStyleValues[i] = float32(v*22) / 256
+	}
+}
+
+// SetLightStyle sets the brightness pattern of the light
+// style for the current level, like QuakeC's lightstyle.
+// e.g. "a" turns a switchable light off and "m" turns it
+// back on. An empty pattern is normal brightness.
+func SetLightStyle(style int, pattern string) error {
+	if style < 0 || style >= maxLightStyles {
+		return ErrInvalidLightStyle
+	}
+	currentMap.lightStyles[style] = pattern
+	return nil
+}
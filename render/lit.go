@@ -0,0 +1,50 @@
+package render
+
+import (
+	"fmt"
+	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/render/atlas"
+)
+
+// Padding around each light map in the light atlases
+const lightPadding = 1
+
+// loadLit loads the level's .lit file, if it has one,
+// into b. A broken .lit file isn't fatal as the level can
+// still use its mono light maps.
+func loadLit(b *bsp.File, name string) {
+	// Half-Life maps already have coloured light maps
+	if b.ColouredLightMaps != nil {
+		return
+	}
+	r := pakFile.Reader("maps/" + name + ".lit")
+	if r == nil {
+		return
+	}
+	if err := b.ParseLit(r); err != nil {
+		fmt.Printf("level %s: %s.lit: %s\n", name, name, err)
+	}
+}
+
+// addColouredLight copies the light map's RGB data into
+// the coloured light atlas at the same position as the
+// mono version in the light atlas, padded the same way.
+func (m *qMap) addColouredLight(r *atlas.Rect, l li) {
+	w := l.pic.Width + lightPadding*2
+	h := l.pic.Height + lightPadding*2
+	x := r.X - lightPadding
+	y := r.Y - lightPadding
+	channel := make([]byte, l.pic.Width*l.pic.Height)
+	padded := make([]byte, w*h)
+	for c := 0; c < 3; c++ {
+		for i := range channel {
+			channel[i] = l.colour[i*3+c]
+		}
+		atlas.CopyImage(channel, padded, 0, 0, w, h, w, h, lightPadding)
+		for py := 0; py < h; py++ {
+			for px := 0; px < w; px++ {
+				m.colouredLight[((y+py)*atlasSize+x+px)*3+c] = padded[py*w+px]
+			}
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package render
+
+import (
+	"fmt"
+	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/mdl"
+	"github.com/thinkofdeath/goquake/render/atlas"
+	"github.com/thinkofdeath/goquake/render/builder"
+	"github.com/thinkofdeath/goquake/render/gl"
+	"github.com/thinkofdeath/goquake/vmath"
+	"math"
+	"strings"
+	"time"
+)
+
+// classModel is the model used to display an entity in
+// the level.
+type classModel struct {
+	model string
+	skin  int
+	frame int
+}
+
+// Quake's game code decides which model each entity uses,
+// without it we use a table of the entities that have
+// alias models. This is a placeholder until the game code
+// is run and only covers id1's entities.
+var classModels = map[string]classModel{
+	"monster_army":                  {model: "progs/soldier.mdl"},
+	"monster_dog":                   {model: "progs/dog.mdl"},
+	"monster_ogre":                  {model: "progs/ogre.mdl"},
+	"monster_ogre_marksman":         {model: "progs/ogre.mdl"},
+	"monster_knight":                {model: "progs/knight.mdl"},
+	"monster_hell_knight":           {model: "progs/hknight.mdl"},
+	"monster_zombie":                {model: "progs/zombie.mdl"},
+	"monster_wizard":                {model: "progs/wizard.mdl"},
+	"monster_demon1":                {model: "progs/demon.mdl"},
+	"monster_shambler":              {model: "progs/shambler.mdl"},
+	"monster_shalrath":              {model: "progs/shalrath.mdl"},
+	"monster_enforcer":              {model: "progs/enforcer.mdl"},
+	"monster_fish":                  {model: "progs/fish.mdl"},
+	"monster_tarbaby":               {model: "progs/tarbaby.mdl"},
+	"monster_boss":                  {model: "progs/boss.mdl"},
+	"monster_oldone":                {model: "progs/oldone.mdl"},
+	"weapon_supershotgun":           {model: "progs/g_shot.mdl"},
+	"weapon_nailgun":                {model: "progs/g_nail.mdl"},
+	"weapon_supernailgun":           {model: "progs/g_nail2.mdl"},
+	"weapon_grenadelauncher":        {model: "progs/g_rock.mdl"},
+	"weapon_rocketlauncher":         {model: "progs/g_rock2.mdl"},
+	"weapon_lightning":              {model: "progs/g_light.mdl"},
+	"item_armor1":                   {model: "progs/armor.mdl", skin: 0},
+	"item_armor2":                   {model: "progs/armor.mdl", skin: 1},
+	"item_armorInv":                 {model: "progs/armor.mdl", skin: 2},
+	"item_artifact_invulnerability": {model: "progs/invulner.mdl"},
+	"item_artifact_envirosuit":      {model: "progs/suit.mdl"},
+	"item_artifact_invisibility":    {model: "progs/invisibl.mdl"},
+	"item_artifact_super_damage":    {model: "progs/quaddama.mdl"},
+	"light_torch_small_walltorch":   {model: "progs/flame.mdl"},
+	"light_flame_large_yellow":      {model: "progs/flame2.mdl", frame: 1},
+	"light_flame_small_yellow":      {model: "progs/flame2.mdl"},
+	"light_flame_small_white":       {model: "progs/flame2.mdl"},
+}
+
+const (
+	// Rate that frame sequences are played at, Quake's game
+	// code runs animations at 10 frames a second.
+	modelFrameRate = 10
+	// Light level (row in the colour map) used for models.
+	// This is a placeholder, Quake lights each model by
+	// sampling the light map below it (R_LightPoint).
+	modelLight = 0.5
+)
+
+var (
+	modelVertexSerializer func(*builder.Buffer, interface{})
+	modelVertexTypes      []builder.Type
+	modelTexSerializer    func(*builder.Buffer, interface{})
+	modelTexTypes         []builder.Type
+
+	// Used for timing animations
+	startTime = time.Now()
+)
+
+type modelVertex struct {
+	X float32
+	Y float32
+	Z float32
+}
+
+type modelTexVertex struct {
+	TextureX float32
+	TextureY float32
+}
+
+func init() {
+	modelVertexSerializer, modelVertexTypes = builder.Struct(modelVertex{})
+	modelTexSerializer, modelTexTypes = builder.Struct(modelTexVertex{})
+}
+
+// qModel is an alias model uploaded to the gpu. Every
+// frame of the model is stored in a single buffer one
+// after another.
+type qModel struct {
+	model *mdl.Model
+	skins []*atlas.Rect
+
+	// frames maps frame group and frame to the index of the
+	// frame in the buffer.
+	frames [][]int
+
+	vertexArray     gl.VertexArray
+	positionBuffer  gl.Buffer
+	texCoordsBuffer gl.Buffer
+	count           int
+}
+
+// modelEntity is an instance of a model placed in the
+// level.
+type modelEntity struct {
+	model  *qModel
+	leaf   *bsp.Leaf
+	origin vmath.Vector3
+	angle  float32
+	skin   int
+	// sequence is the list of frame groups that the entity
+	// cycles through.
+	sequence []int
+}
+
+// loadModels loads the models used by entities in the
+// map, adding the skins used to the atlas. Entities whose
+// skin doesn't fit in the atlas are skipped. This must be
+// called before the atlas is baked.
+func (m *qMap) loadModels() {
+	m.models = map[string]*qModel{}
+	for _, e := range m.bsp.Entities {
+		cm, ok := classModels[e.ClassName()]
+		if !ok {
+			continue
+		}
+		model, ok := m.models[cm.model]
+		if !ok {
+			model = m.loadModel(cm.model)
+			m.models[cm.model] = model
+		}
+		if model == nil {
+			continue
+		}
+
+		origin, _ := e.Origin()
+		angle, _ := e.Angle()
+		skin := cm.skin
+		if skin >= len(model.skins) {
+			skin = 0
+		}
+		// Only add the skins that are used to save space
+		// in the atlas
+		if model.skins[skin] == nil {
+			r, err := m.atlas.Add(model.model.Skins[skin].Pictures[0])
+			if err != nil {
+				fmt.Printf("skipping %s skin %d: %s\n", cm.model, skin, err)
+				// Don't try adding it for every entity
+				model.skins[skin] = skippedSkin
+				continue
+			}
+			model.skins[skin] = r
+		}
+		if model.skins[skin] == skippedSkin {
+			continue
+		}
+		frame := cm.frame
+		if frame >= len(model.model.Frames) {
+			frame = 0
+		}
+		m.entities = append(m.entities, &modelEntity{
+			model:    model,
+			leaf:     m.bsp.PointInLeaf(origin),
+			origin:   origin,
+			angle:    angle,
+			skin:     skin,
+			sequence: frameSequence(model.model, frame),
+		})
+	}
+}
+
+// skippedSkin marks skins that didn't fit in the atlas
+var skippedSkin = &atlas.Rect{}
+
+// loadModel loads the named model, nil is returned if the
+// model couldn't be loaded.
+func (m *qMap) loadModel(name string) *qModel {
+	r := pakFile.Reader(name)
+	if r == nil {
+		// Models from the full game are missing from the
+		// shareware version
+		return nil
+	}
+	model, err := mdl.ParseMDLFile(r)
+	if err != nil {
+		fmt.Printf("failed to load %s: %s\n", name, err)
+		return nil
+	}
+
+	if len(model.Skins) == 0 || len(model.Frames) == 0 {
+		fmt.Printf("failed to load %s: no skins or frames\n", name)
+		return nil
+	}
+	return &qModel{
+		model: model,
+		skins: make([]*atlas.Rect, len(model.Skins)),
+	}
+}
+
+// frameSequence returns the frame groups that make up the
+// animation starting at the passed frame. Frames in a
+// sequence share the same name with a different number on
+// the end (e.g. stand1, stand2...).
+func frameSequence(model *mdl.Model, start int) []int {
+	seq := []int{start}
+	prefix := framePrefix(model.Frames[start].Frames[0].Name)
+	for i := start + 1; i < len(model.Frames); i++ {
+		if framePrefix(model.Frames[i].Frames[0].Name) != prefix {
+			break
+		}
+		seq = append(seq, i)
+	}
+	return seq
+}
+
+func framePrefix(name string) string {
+	return strings.TrimRight(name, "0123456789")
+}
+
+// upload creates the buffers for the model on the gpu.
+// The skins must have been added to the atlas before
+// this is called.
+func (q *qModel) upload() {
+	model := q.model
+	q.count = len(model.Triangles) * 3
+
+	positions := builder.New(modelVertexTypes...)
+	texCoords := builder.New(modelTexTypes...)
+
+	for _, tri := range model.Triangles {
+		for _, v := range tri.Vertices {
+			tc := model.TexCoords[v]
+			s := float32(tc.S)
+			if !tri.FacesFront && tc.OnSeam {
+				s += float32(model.SkinWidth / 2)
+			}
+			modelTexSerializer(texCoords, modelTexVertex{
+				TextureX: s + 0.5,
+				TextureY: float32(tc.T) + 0.5,
+			})
+		}
+	}
+
+	q.frames = make([][]int, len(model.Frames))
+	index := 0
+	for i, group := range model.Frames {
+		for _, frame := range group.Frames {
+			for _, tri := range model.Triangles {
+				for _, v := range tri.Vertices {
+					p := frame.Positions[v]
+					modelVertexSerializer(positions, modelVertex{
+						X: p.X,
+						Y: p.Y,
+						Z: p.Z,
+					})
+				}
+			}
+			q.frames[i] = append(q.frames[i], index)
+			index++
+		}
+	}
+
+	q.vertexArray = gl.CreateVertexArray()
+	q.vertexArray.Bind()
+	q.texCoordsBuffer = gl.CreateBuffer()
+	q.texCoordsBuffer.Bind(gl.ArrayBuffer)
+	q.texCoordsBuffer.Data(texCoords.Data(), gl.StaticDraw)
+	gameModelShader.setupTexturePointer()
+
+	q.positionBuffer = gl.CreateBuffer()
+	q.positionBuffer.Bind(gl.ArrayBuffer)
+	q.positionBuffer.Data(positions.Data(), gl.StaticDraw)
+}
+
+func (q *qModel) cleanup() {
+	q.vertexArray.Delete()
+	q.positionBuffer.Delete()
+	q.texCoordsBuffer.Delete()
+}
+
+// currentFrame returns the two frames (as indices into the
+// model's buffer) to blend between and how far between
+// them the entity is at the passed time.
+func (e *modelEntity) currentFrame(t float64) (frame, next int, lerp float32) {
+	model := e.model
+	group := e.sequence[0]
+
+	// Frame groups animate on their own
+	if g := model.model.Frames[group]; len(g.Frames) > 1 {
+		total := float64(g.Intervals[len(g.Intervals)-1])
+		if total <= 0 {
+			return model.frames[group][0], model.frames[group][0], 0
+		}
+		t = math.Mod(t, total)
+		start := 0.0
+		for i, end := range g.Intervals {
+			if t < float64(end) || i == len(g.Intervals)-1 {
+				n := (i + 1) % len(g.Frames)
+				lerp = float32((t - start) / (float64(end) - start))
+				return model.frames[group][i], model.frames[group][n], lerp
+			}
+			start = float64(end)
+		}
+	}
+
+	pos := t * modelFrameRate
+	i := int(pos) % len(e.sequence)
+	n := (i + 1) % len(e.sequence)
+	lerp = float32(pos - math.Floor(pos))
+	return model.frames[e.sequence[i]][0], model.frames[e.sequence[n]][0], lerp
+}
+
+var modelMatrix = vmath.NewMatrix4()
+
+// renderModels draws all entities that are in the
+// potentially visible set of the camera.
+func (m *qMap) renderModels() {
+	if len(m.entities) == 0 {
+		return
+	}
+	t := time.Now().Sub(startTime).Seconds()
+
+	// Models don't have a consistent winding order
+	gl.Disable(gl.CullFaceFlag)
+	gameModelShader.bind()
+
+	for _, e := range m.entities {
+		// Entities outside of the level can't be culled
+		inLevel := e.leaf != nil && e.leaf.ID != 0
+		if m.visLeaves != nil && inLevel && !m.visLeaves[e.leaf] {
+			continue
+		}
+		model := e.model
+		frame, next, lerp := e.currentFrame(t)
+
+		angle := float64(e.angle)
+		if model.model.Flags&mdl.FlagRotate != 0 {
+			angle = math.Mod(t*100, 360)
+		}
+		modelMatrix.Identity()
+		modelMatrix.RotateZ(float32(-angle * (math.Pi / 180)))
+		modelMatrix.Translate(e.origin.X, e.origin.Y, e.origin.Z)
+
+		gameModelShader.ModelMatrix.Matrix4(false, modelMatrix)
+		gameModelShader.Light.Float(modelLight - m.dynamicLightAt(e.origin))
+		gameModelShader.Lerp.Float(lerp)
+		skin := model.skins[e.skin]
+		gameModelShader.SkinOffset.Float2(float32(skin.X), float32(skin.Y))
+
+		model.vertexArray.Bind()
+		model.positionBuffer.Bind(gl.ArrayBuffer)
+		gameModelShader.setupPositionPointers(frame*model.count, next*model.count)
+		gl.DrawArrays(gl.Triangles, 0, model.count)
+	}
+
+	gameModelShader.unbind()
+	gl.Enable(gl.CullFaceFlag)
+}
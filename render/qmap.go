@@ -1,17 +1,14 @@
 package render
 
 import (
+	"errors"
+	"fmt"
 	"github.com/thinkofdeath/goquake/bsp"
-	"github.com/thinkofdeath/goquake/pak"
 	"github.com/thinkofdeath/goquake/render/atlas"
 	"github.com/thinkofdeath/goquake/render/builder"
 	"github.com/thinkofdeath/goquake/render/gl"
 	"github.com/thinkofdeath/goquake/vmath"
-	"image"
-	"image/png"
-	"io/ioutil"
 	"math"
-	"os"
 	"sort"
 	"strings"
 	"time"
@@ -24,6 +21,9 @@ type qMap struct {
 	mipTextures [3][]byte
 	lightAtlas  *atlas.Type
 	textures    []*atlas.Rect
+	// RGB copy of the light atlas, nil if the level has no
+	// coloured light maps
+	colouredLight []byte
 
 	mapVertexArray gl.VertexArray
 	mapBuffer      gl.Buffer
@@ -39,6 +39,52 @@ type qMap struct {
 	skyTexture        int
 	skyMin            vmath.Vector3
 	skyMax            vmath.Vector3
+
+	waterVertexArray gl.VertexArray
+	waterBuffer      gl.Buffer
+	waterCount       int
+	waterFaces       map[*bsp.Face]waterFace
+
+	// The world followed by the level's other models, in
+	// the order of the bsp's models
+	brushModels []*brushModel
+
+	// Used for culling the level using the potentially
+	// visible set of the camera's current leaf
+	faceRanges   map[*bsp.Face]drawRange
+	visLeaf      *bsp.Leaf
+	visValid     bool
+	visRanges    []drawRange
+	visSkyRanges []drawRange
+	visWater     []waterFace
+	// visLeaves is the set of leaves visible from the
+	// camera's leaf or nil if everything is visible
+	visLeaves map[*bsp.Leaf]bool
+
+	models   map[string]*qModel
+	entities []*modelEntity
+
+	// Animated textures, see animationSlot
+	animations      []*textureAnimation
+	animationSlots  map[animationKey]uint8
+	animOffsets     []float32
+	alternateModels map[int]bool
+
+	// Light style patterns and their current brightness,
+	// see updateLightStyles
+	lightStyles      [maxLightStyles]string
+	lightStyleValues []float32
+
+	// See updateDynamicLights
+	dynamicLights   []*dynamicLight
+	lastLightUpdate time.Time
+	lightPositions  []float32
+	lightColours    []float32
+
+	sprites           map[string]*qSprite
+	spriteEntities    []*spriteEntity
+	spriteVertexArray gl.VertexArray
+	spriteBuffer      gl.Buffer
 }
 
 var (
@@ -59,23 +105,51 @@ type mapVertex struct {
 	LightX         int16
 	LightY         int16
 	Light          uint8
-	LightType      uint8
+	// The styles of the face's light maps. The sky shader
+	// uses the first to pick the layer of the sky.
+	LightStyle0 uint8
+	LightStyle1 uint8
+	LightStyle2 uint8
+	LightStyle3 uint8
+	Animation   uint8
+	// Turbulent is set for liquids and teleporters which
+	// are warped
+	Turbulent uint8
+	// Distance between each style's light map in the
+	// light atlas
+	LightHeight uint8
 }
 
 func init() {
 	vertexSerializer, vertexTypes = builder.Struct(mapVertex{})
 }
 
-func newQMap(b *bsp.File) *qMap {
+// ErrTooManySkyTextures is returned for levels that use
+// more than one sky texture
+var ErrTooManySkyTextures = errors.New("too many sky textures")
+
+// newQMap builds the level ready for drawing. An error is
+// returned if the level can't be drawn, e.g. if its
+// textures don't fit in the atlas. Nothing is uploaded to
+// the gpu until the level has been built so a failure
+// leaves the current level untouched.
+func newQMap(b *bsp.File) (*qMap, error) {
 	m := &qMap{
 		bsp:   b,
 		atlas: atlas.New(atlasSize, atlasSize),
 		// Pad the light buffer to fix issues with smoothing
 		// the texture
-		lightAtlas: atlas.NewPadded(atlasSize, atlasSize, 1),
-		skyTexture: -1,
-		textures:   make([]*atlas.Rect, len(b.Textures)),
+		lightAtlas:      atlas.NewPadded(atlasSize, atlasSize, lightPadding),
+		skyTexture:      -1,
+		textures:        make([]*atlas.Rect, len(b.Textures)),
+		animationSlots:  map[animationKey]uint8{},
+		animOffsets:     make([]float32, maxAnimations*2),
+		alternateModels: map[int]bool{},
+		lastLightUpdate: time.Now(),
+		lightPositions:  make([]float32, maxDynamicLights*4),
+		lightColours:    make([]float32, maxDynamicLights*3),
 	}
+	m.initLightStyles()
 
 	// Allocate mipmaps
 	for j := 0; j < 3; j++ {
@@ -89,14 +163,16 @@ func newQMap(b *bsp.File) *qMap {
 		if texture == nil {
 			continue
 		}
-
 		tList = append(tList, ti{i, texture})
 	}
 	sort.Sort(tiSorter(tList))
 
 	// Add all textures to the atlas
 	for _, t := range tList {
-		tx := m.atlas.Add(t.texture.Pictures[0])
+		tx, err := m.atlas.Add(t.texture.Pictures[0])
+		if err != nil {
+			return nil, fmt.Errorf("texture %s: %s", t.texture.Name, err)
+		}
 		m.textures[t.id] = tx
 
 		// Mipmaps
@@ -114,10 +190,13 @@ func newQMap(b *bsp.File) *qMap {
 			)
 		}
 	}
+	m.loadModels()
+	m.loadSprites()
 	m.atlas.Bake()
 
 	bufferNormal := builder.New(vertexTypes...)
 	bufferSky := builder.New(vertexTypes...)
+	bufferWater := builder.New(vertexTypes...)
 	m.stride = bufferNormal.ElementSize()
 
 	var lList []li
@@ -128,68 +207,76 @@ func newQMap(b *bsp.File) *qMap {
 			if face.TextureInfo.Texture == nil || face.TextureInfo.Texture.Name == "trigger" {
 				continue
 			}
-			if face.LightMap == -1 || face.TypeLight == 0xFF {
+			count := face.LightMapCount()
+			if count == 0 || strings.HasPrefix(face.TextureInfo.Texture.Name, "*") {
 				continue
 			}
 
-			minS := math.Inf(1)
-			minT := math.Inf(1)
-			maxS := math.Inf(-1)
-			maxT := math.Inf(-1)
+			width, height := b.LightMapSize(face)
 
-			tInfo := face.TextureInfo
-			for _, l := range face.Ledges {
-				var vert *vmath.Vector3
-				if l < 0 {
-					vert = b.Edges[-l].Vertex1
-				} else {
-					vert = b.Edges[l].Vertex0
-				}
-
-				valS := float64(vert.Dot(tInfo.VectorS) + tInfo.DistS)
-				valT := float64(vert.Dot(tInfo.VectorT) + tInfo.DistT)
+			// Each style's light map is stacked below the
+			// last with the last row repeated so that they
+			// don't blend together
+			size := width * height
+			if height+1 > math.MaxUint8 {
+				count = 1
+			}
+			data := make([]byte, 0, (size+width)*count)
+			for i := 0; i < count; i++ {
+				layer := b.LightMaps[int(face.LightMap)+size*i:][:size]
+				data = append(data, layer...)
+				data = append(data, layer[size-width:]...)
+			}
 
-				if minS > valS {
-					minS = valS
-				}
-				if maxS < valS {
-					maxS = valS
-				}
-				if minT > valT {
-					minT = valT
-				}
-				if maxT < valT {
-					maxT = valT
+			var colour []byte
+			if b.ColouredLightMaps != nil {
+				colour = make([]byte, 0, len(data)*3)
+				for i := 0; i < count; i++ {
+					layer := b.ColouredLightMaps[(int(face.LightMap)+size*i)*3:][:size*3]
+					colour = append(colour, layer...)
+					colour = append(colour, layer[(size-width)*3:]...)
 				}
 			}
 
-			lightS := math.Floor(minS / 16)
-			lightT := math.Floor(minT / 16)
-			lightSM := math.Ceil(maxS / 16)
-			lightTM := math.Ceil(maxT / 16)
-
-			width := (lightSM - lightS) + 1.0
-			height := (lightTM - lightT) + 1.0
-
 			lList = append(lList, li{
 				int(face.LightMap),
 				&bsp.Picture{
-					Width:  int(width),
-					Height: int(height),
-					Data:   b.LightMaps[face.LightMap:],
+					Width:  width,
+					Height: (height + 1) * count,
+					Data:   data,
 				},
+				height + 1,
+				colour,
 			})
 		}
 	}
 	// Add them to an atlas
 	sort.Sort(liSorter(lList))
 	lights := map[int32]*atlas.Rect{}
+	lightHeights := map[int32]int{}
+	if b.ColouredLightMaps != nil {
+		m.colouredLight = make([]byte, atlasSize*atlasSize*3)
+	}
 	for _, l := range lList {
-		lights[int32(l.id)] = m.lightAtlas.Add(l.pic)
+		r, err := m.lightAtlas.Add(l.pic)
+		if err != nil {
+			return nil, fmt.Errorf("light maps: %s", err)
+		}
+		lights[int32(l.id)] = r
+		lightHeights[int32(l.id)] = l.layerHeight
+		if l.colour != nil {
+			m.addColouredLight(r, l)
+		}
 	}
 
 	// Build the world
-	for _, model := range b.Models {
+	m.faceRanges = make(map[*bsp.Face]drawRange)
+	m.waterFaces = make(map[*bsp.Face]waterFace)
+	for mi, model := range b.Models {
+		// The model's vertices are relative to its origin,
+		// the model's matrix moves them into place
+		bm := newBrushModel(model.Origin)
+		normalStart, skyStart := bufferNormal.Count(), bufferSky.Count()
 		for _, face := range model.Faces {
 			if face.TextureInfo.Texture == nil || face.TextureInfo.Texture.Name == "trigger" {
 				continue
@@ -197,21 +284,28 @@ func newQMap(b *bsp.File) *qMap {
 
 			var data *builder.Buffer
 			var isSky bool
+			var turbulent uint8
+			kind := surfaceNormal
 			if strings.HasPrefix(face.TextureInfo.Texture.Name, "sky") {
 				if m.skyTexture != -1 && m.skyTexture != face.TextureInfo.Texture.ID {
-					panic("too many sky textures")
+					return nil, ErrTooManySkyTextures
 				}
 				m.skyTexture = face.TextureInfo.Texture.ID
 				data = bufferSky
 				isSky = true
+				kind = surfaceSky
+			} else if strings.HasPrefix(face.TextureInfo.Texture.Name, "*") {
+				data = bufferWater
+				turbulent = 1
+				kind = surfaceWater
 			} else {
 				data = bufferNormal
 			}
+			start := data.Count()
 
-			switch face.TextureInfo.Texture.Name[0] {
-			case '+', '*':
-				face.BaseLight = 127
-				face.TypeLight = 0xFF
+			var anim uint8
+			if !isSky {
+				anim = m.animationSlot(mi, face.TextureInfo.Texture)
 			}
 
 			centerX := 0.0
@@ -235,16 +329,25 @@ func newQMap(b *bsp.File) *qMap {
 			centerY /= ec
 			centerZ /= ec
 
-			light := face.BaseLight
-			if face.BaseLight == 255 {
-				light = 0
+			// Liquids aren't lit and are drawn at normal
+			// brightness
+			var light uint8
+			if turbulent != 0 {
+				light = 127
 			}
 
 			tOffsetX := 0.0
 			tOffsetY := 0.0
 			var lightS, lightT float64
 
-			if face.TypeLight != 0xFF && face.LightMap != -1 {
+			lightTex, lit := lights[face.LightMap]
+			styles := face.Styles
+			if !lit {
+				styles = [4]uint8{bsp.NoStyle, bsp.NoStyle, bsp.NoStyle, bsp.NoStyle}
+			}
+			lightHeight := uint8(lightHeights[face.LightMap])
+
+			if lit {
 				minS := math.Inf(1)
 				minT := math.Inf(1)
 				maxS := math.Inf(-1)
@@ -279,9 +382,8 @@ func newQMap(b *bsp.File) *qMap {
 				lightS = math.Floor(minS / 16)
 				lightT = math.Floor(minT / 16)
 
-				tex := lights[face.LightMap]
-				tOffsetX = float64(tex.X)
-				tOffsetY = float64(tex.Y)
+				tOffsetX = float64(lightTex.X)
+				tOffsetY = float64(lightTex.Y)
 			}
 
 			s := face.TextureInfo.VectorS
@@ -328,15 +430,15 @@ func newQMap(b *bsp.File) *qMap {
 
 				aTX := -1.0
 				aTY := -1.0
-				if face.LightMap != -1 {
+				if lit {
 					aTX = math.Floor(aS/16) - lightS
 					aTY = math.Floor(aT/16) - lightT
 				}
 
 				vertexSerializer(data, mapVertex{
-					X:              model.Origin.X + float32(av.X),
-					Y:              model.Origin.Y + float32(av.Y),
-					Z:              model.Origin.Z + float32(av.Z),
+					X:              av.X,
+					Y:              av.Y,
+					Z:              av.Z,
 					TextureX:       uint16(tex.X),
 					TextureY:       uint16(tex.Y),
 					TextureOffsetX: int16(aS),
@@ -346,7 +448,13 @@ func newQMap(b *bsp.File) *qMap {
 					LightX:         int16(tOffsetX + aTX),
 					LightY:         int16(tOffsetY + aTY),
 					Light:          light,
-					LightType:      face.TypeLight,
+					LightStyle0:    styles[0],
+					LightStyle1:    styles[1],
+					LightStyle2:    styles[2],
+					LightStyle3:    styles[3],
+					Animation:      anim,
+					Turbulent:      turbulent,
+					LightHeight:    lightHeight,
 				})
 
 				bS := float64(bv.Dot(s) + face.TextureInfo.DistS)
@@ -354,15 +462,15 @@ func newQMap(b *bsp.File) *qMap {
 
 				bTX := -1.0
 				bTY := -1.0
-				if face.LightMap != -1 {
+				if lit {
 					bTX = math.Floor(bS/16) - lightS
 					bTY = math.Floor(bT/16) - lightT
 				}
 
 				vertexSerializer(data, mapVertex{
-					X:              model.Origin.X + float32(bv.X),
-					Y:              model.Origin.Y + float32(bv.Y),
-					Z:              model.Origin.Z + float32(bv.Z),
+					X:              bv.X,
+					Y:              bv.Y,
+					Z:              bv.Z,
 					TextureX:       uint16(tex.X),
 					TextureY:       uint16(tex.Y),
 					TextureOffsetX: int16(bS),
@@ -372,7 +480,13 @@ func newQMap(b *bsp.File) *qMap {
 					LightX:         int16(tOffsetX + bTX),
 					LightY:         int16(tOffsetY + bTY),
 					Light:          light,
-					LightType:      face.TypeLight,
+					LightStyle0:    styles[0],
+					LightStyle1:    styles[1],
+					LightStyle2:    styles[2],
+					LightStyle3:    styles[3],
+					Animation:      anim,
+					Turbulent:      turbulent,
+					LightHeight:    lightHeight,
 				})
 
 				centerVec := vmath.Vector3{
@@ -385,15 +499,15 @@ func newQMap(b *bsp.File) *qMap {
 
 				centerTX := -1.0
 				centerTY := -1.0
-				if face.LightMap != -1 {
+				if lit {
 					centerTX = math.Floor(centerS/16) - lightS
 					centerTY = math.Floor(centerT/16) - lightT
 				}
 
 				vertexSerializer(data, mapVertex{
-					X:              model.Origin.X + float32(centerX),
-					Y:              model.Origin.Y + float32(centerY),
-					Z:              model.Origin.Z + float32(centerZ),
+					X:              float32(centerX),
+					Y:              float32(centerY),
+					Z:              float32(centerZ),
 					TextureX:       uint16(tex.X),
 					TextureY:       uint16(tex.Y),
 					TextureOffsetX: int16(centerS),
@@ -403,14 +517,43 @@ func newQMap(b *bsp.File) *qMap {
 					LightX:         int16(tOffsetX + centerTX),
 					LightY:         int16(tOffsetY + centerTY),
 					Light:          light,
-					LightType:      face.TypeLight,
+					LightStyle0:    styles[0],
+					LightStyle1:    styles[1],
+					LightStyle2:    styles[2],
+					LightStyle3:    styles[3],
+					Animation:      anim,
+					Turbulent:      turbulent,
+					LightHeight:    lightHeight,
 				})
 			}
+
+			m.faceRanges[face] = drawRange{start, data.Count() - start, kind}
+			if turbulent != 0 {
+				m.waterFaces[face] = waterFace{
+					r:     m.faceRanges[face],
+					model: mi,
+					center: vmath.Vector3{
+						X: float32(centerX),
+						Y: float32(centerY),
+						Z: float32(centerZ),
+					},
+				}
+			}
 		}
+		bm.normal = drawRange{normalStart, bufferNormal.Count() - normalStart, surfaceNormal}
+		bm.sky = drawRange{skyStart, bufferSky.Count() - skyStart, surfaceSky}
+		m.brushModels = append(m.brushModels, bm)
 	}
 
 	m.lightAtlas.Bake()
 
+	for _, model := range m.models {
+		if model != nil {
+			model.upload()
+		}
+	}
+	m.initSprites()
+
 	m.mapVertexArray = gl.CreateVertexArray()
 	m.mapVertexArray.Bind()
 	m.mapBuffer = gl.CreateBuffer()
@@ -427,6 +570,14 @@ func newQMap(b *bsp.File) *qMap {
 	m.skyCount = bufferSky.Count()
 	gameSkyShader.setupPointers(m.stride)
 
+	m.waterVertexArray = gl.CreateVertexArray()
+	m.waterVertexArray.Bind()
+	m.waterBuffer = gl.CreateBuffer()
+	m.waterBuffer.Bind(gl.ArrayBuffer)
+	m.waterBuffer.Data(bufferWater.Data(), gl.StaticDraw)
+	m.waterCount = bufferWater.Count()
+	gameShader.setupPointers(m.stride)
+
 	// Stretch the sky box slightly bigger than the level
 	m.skyMax.X += 2000
 	m.skyMax.Y += 2000
@@ -453,8 +604,127 @@ func newQMap(b *bsp.File) *qMap {
 
 	textureLight.Bind(gl.Texture2D)
 	textureLight.Image2D(0, gl.Red, atlasSize, atlasSize, gl.Red, gl.UnsignedByte, m.lightAtlas.Buffer)
+	if m.colouredLight != nil {
+		textureLightColour.Bind(gl.Texture2D)
+		textureLightColour.Image2D(0, gl.RGB, atlasSize, atlasSize, gl.RGB, gl.UnsignedByte, m.colouredLight)
+	}
+
+	return m, nil
+}
 
-	return m
+// drawRange is a range of vertices within one of the
+// map's buffers.
+type drawRange struct {
+	offset, count int
+	kind          surfaceKind
+}
+
+// surfaceKind is the buffer a face is stored in
+type surfaceKind int
+
+const (
+	surfaceNormal surfaceKind = iota
+	surfaceSky
+	surfaceWater
+)
+
+// waterFace is a visible water face, these are drawn
+// separately from back to front so that they can be
+// translucent.
+type waterFace struct {
+	r     drawRange
+	model int
+	// center is relative to the model's origin, position
+	// is where the model's transform currently places it
+	center   vmath.Vector3
+	position vmath.Vector3
+}
+
+// updateVisibility recomputes the ranges of the map to
+// draw if the camera has moved into a different leaf.
+func (m *qMap) updateVisibility(camera vmath.Vector3) {
+	leaf := m.bsp.PointInLeaf(camera)
+	if m.visValid && leaf == m.visLeaf {
+		return
+	}
+	m.visLeaf = leaf
+	m.visValid = true
+	m.visRanges = m.visRanges[:0]
+	m.visSkyRanges = m.visSkyRanges[:0]
+	m.visWater = m.visWater[:0]
+	m.visLeaves = nil
+
+	// Outside of the level, draw everything
+	if leaf == nil || leaf.ID == 0 {
+		world := m.brushModels[0]
+		m.visRanges = append(m.visRanges, world.normal)
+		m.visSkyRanges = append(m.visSkyRanges, world.sky)
+		for _, w := range m.waterFaces {
+			m.visWater = append(m.visWater, w)
+		}
+		return
+	}
+
+	m.visLeaves = map[*bsp.Leaf]bool{leaf: true}
+	added := map[*bsp.Face]bool{}
+	add := func(f *bsp.Face) {
+		r, ok := m.faceRanges[f]
+		if !ok || added[f] {
+			return
+		}
+		added[f] = true
+		switch r.kind {
+		case surfaceSky:
+			m.visSkyRanges = append(m.visSkyRanges, r)
+		case surfaceWater:
+			m.visWater = append(m.visWater, m.waterFaces[f])
+		default:
+			m.visRanges = append(m.visRanges, r)
+		}
+	}
+	for _, l := range m.bsp.VisibleLeaves(leaf) {
+		m.visLeaves[l] = true
+		for _, f := range l.Faces {
+			add(f)
+		}
+	}
+	// Only the world model's faces are part of the
+	// visibility information, the other models are always
+	// drawn
+	for _, w := range m.waterFaces {
+		if w.model != 0 {
+			m.visWater = append(m.visWater, w)
+		}
+	}
+
+	m.visRanges = mergeRanges(m.visRanges)
+	m.visSkyRanges = mergeRanges(m.visSkyRanges)
+}
+
+// mergeRanges sorts the ranges and joins ranges that
+// follow on from each other to reduce the number of
+// draw calls.
+func mergeRanges(ranges []drawRange) []drawRange {
+	if len(ranges) == 0 {
+		return ranges
+	}
+	sort.Sort(rangeSorter(ranges))
+	out := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &out[len(out)-1]
+		if last.offset+last.count == r.offset {
+			last.count += r.count
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func drawRanges(ranges []drawRange) {
+	for _, r := range ranges {
+		gl.DrawArrays(gl.Triangles, r.offset, r.count)
+	}
 }
 
 func (m *qMap) render() {
@@ -476,6 +746,12 @@ func (m *qMap) render() {
 	gl.Clear(gl.StencilBufferBit)
 	gl.StencilOp(gl.Keep, gl.Keep, gl.Replace)
 
+	m.updateVisibility(vmath.Vector3{
+		X: float32(cameraX),
+		Y: float32(cameraY),
+		Z: float32(cameraZ),
+	})
+
 	// Hacky but -1 for time offset just fills a single
 	// color.
 	// TODO(Think) Separate shader?
@@ -485,14 +761,14 @@ func (m *qMap) render() {
 	// We only want the depth information
 	gl.StencilMask(0x00)
 	m.mapVertexArray.Bind()
-	gl.DrawArrays(gl.Triangles, 0, m.count)
+	m.drawModels(gameSkyShader.ModelMatrix, m.visRanges, surfaceNormal)
 	gl.StencilMask(0xFF)
 
 	// Fill the stencil buffer with the location of the sky
 	// quads
 	gl.StencilFunc(gl.Always, 1, 0xFF)
 	m.skyVertexArray.Bind()
-	gl.DrawArrays(gl.Triangles, 0, m.skyCount)
+	m.drawModels(gameSkyShader.ModelMatrix, m.visSkyRanges, surfaceSky)
 
 	// Disable stencil writing and re-enable
 	// color writing
@@ -508,6 +784,7 @@ func (m *qMap) render() {
 
 	// Draw the two sky planes
 	m.skyBoxVertexArray.Bind()
+	gameSkyShader.ModelMatrix.Matrix4(false, identityMatrix)
 	t := time.Second * 30
 	gameSkyShader.TimeOffset.Float(float32(time.Now().UnixNano()%int64(t*2)) / float32(t))
 	gl.DrawArrays(gl.Triangles, 0, m.skyBoxCount)
@@ -517,11 +794,66 @@ func (m *qMap) render() {
 	// areas
 	gl.StencilFunc(gl.Equal, 0, 0xFF)
 	gameShader.bind()
+	m.updateAnimations()
+	gameShader.AnimOffsets.Float2Array(m.animOffsets)
+	m.updateLightStyles()
+	gameShader.StyleValues.FloatArray(m.lightStyleValues)
+	m.updateDynamicLights()
+	gameShader.LightPositions.Float4Array(m.lightPositions)
+	gameShader.LightColours.Float3Array(m.lightColours)
+	gameShader.LightCount.Int(len(m.dynamicLights))
+	gameShader.Alpha.Float(1)
 	m.mapVertexArray.Bind()
-	gl.DrawArrays(gl.Triangles, 0, m.count)
+	m.drawModels(gameShader.ModelMatrix, m.visRanges, surfaceNormal)
 	gameShader.unbind()
 
 	gl.Disable(gl.StencilTest)
+
+	m.renderModels()
+	m.renderSprites()
+	m.renderWater()
+}
+
+// renderWater draws the visible water faces after the
+// rest of the level, furthest first so that they blend
+// correctly when translucent.
+func (m *qMap) renderWater() {
+	if len(m.visWater) == 0 {
+		return
+	}
+	camera := vmath.Vector3{
+		X: float32(cameraX),
+		Y: float32(cameraY),
+		Z: float32(cameraZ),
+	}
+	for i := range m.visWater {
+		w := &m.visWater[i]
+		w.position = transformPoint(m.brushModels[w.model].matrix, w.center)
+	}
+	sort.Sort(waterSorter{m.visWater, camera})
+
+	gameShader.bind()
+	gameShader.AnimOffsets.Float2Array(m.animOffsets)
+	gameShader.Alpha.Float(waterAlpha)
+	if waterAlpha < 1 {
+		gl.Enable(gl.Blend)
+		gl.BlendFunc(gl.SrcAlpha, gl.OneMinusSrcAlpha)
+		gl.DepthMask(false)
+	}
+	m.waterVertexArray.Bind()
+	model := -1
+	for _, w := range m.visWater {
+		if w.model != model {
+			model = w.model
+			gameShader.ModelMatrix.Matrix4(false, m.brushModels[model].matrix)
+		}
+		gl.DrawArrays(gl.Triangles, w.r.offset, w.r.count)
+	}
+	if waterAlpha < 1 {
+		gl.DepthMask(true)
+		gl.Disable(gl.Blend)
+	}
+	gameShader.unbind()
 }
 
 func (m *qMap) cleanup() {
@@ -531,6 +863,15 @@ func (m *qMap) cleanup() {
 	m.mapBuffer.Delete()
 	m.skyBoxBuffer.Delete()
 	m.skyBuffer.Delete()
+	m.waterVertexArray.Delete()
+	m.waterBuffer.Delete()
+	m.spriteVertexArray.Delete()
+	m.spriteBuffer.Delete()
+	for _, model := range m.models {
+		if model != nil {
+			model.cleanup()
+		}
+	}
 }
 
 func (m *qMap) buildSkyBox(b *builder.Buffer) {
@@ -551,7 +892,7 @@ func (m *qMap) buildSkyBox(b *builder.Buffer) {
 			TextureY:      uint16(tex.Y),
 			TextureWidth:  w,
 			TextureHeight: int16(tex.Height),
-			LightType:     uint8(z),
+			LightStyle0:   uint8(z),
 		})
 		vertexSerializer(b, mapVertex{
 			X:             m.skyMin.X,
@@ -561,7 +902,7 @@ func (m *qMap) buildSkyBox(b *builder.Buffer) {
 			TextureY:      uint16(tex.Y),
 			TextureWidth:  w,
 			TextureHeight: int16(tex.Height),
-			LightType:     uint8(z),
+			LightStyle0:   uint8(z),
 		})
 		vertexSerializer(b, mapVertex{
 			X:             m.skyMax.X,
@@ -571,7 +912,7 @@ func (m *qMap) buildSkyBox(b *builder.Buffer) {
 			TextureY:      uint16(tex.Y),
 			TextureWidth:  w,
 			TextureHeight: int16(tex.Height),
-			LightType:     uint8(z),
+			LightStyle0:   uint8(z),
 		})
 
 		vertexSerializer(b, mapVertex{
@@ -582,7 +923,7 @@ func (m *qMap) buildSkyBox(b *builder.Buffer) {
 			TextureY:      uint16(tex.Y),
 			TextureWidth:  w,
 			TextureHeight: int16(tex.Height),
-			LightType:     uint8(z),
+			LightStyle0:   uint8(z),
 		})
 		vertexSerializer(b, mapVertex{
 			X:             m.skyMax.X,
@@ -592,7 +933,7 @@ func (m *qMap) buildSkyBox(b *builder.Buffer) {
 			TextureY:      uint16(tex.Y),
 			TextureWidth:  w,
 			TextureHeight: int16(tex.Height),
-			LightType:     uint8(z),
+			LightStyle0:   uint8(z),
 		})
 		vertexSerializer(b, mapVertex{
 			X:             m.skyMax.X,
@@ -602,28 +943,7 @@ func (m *qMap) buildSkyBox(b *builder.Buffer) {
 			TextureY:      uint16(tex.Y),
 			TextureWidth:  w,
 			TextureHeight: int16(tex.Height),
-			LightType:     uint8(z),
+			LightStyle0:   uint8(z),
 		})
 	}
 }
-
-func dumpTexture(data []byte, width, height int, p pak.File, name string) {
-	cm, _ := ioutil.ReadAll(pakFile.Reader("gfx/colormap.lmp"))
-	pm, _ := ioutil.ReadAll(pakFile.Reader("gfx/palette.lmp"))
-
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	for i, d := range data {
-		ind := int(cm[32*64+int(d)])
-		img.Pix[i*4+0] = pm[ind*3+0]
-		img.Pix[i*4+1] = pm[ind*3+1]
-		img.Pix[i*4+2] = pm[ind*3+2]
-		img.Pix[i*4+3] = 0xff
-	}
-
-	f, err := os.Create(name)
-	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
-	png.Encode(f, img)
-}
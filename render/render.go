@@ -3,11 +3,14 @@ package render
 import (
 	"fmt"
 	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/lmp"
+	"github.com/thinkofdeath/goquake/movement"
 	"github.com/thinkofdeath/goquake/pak"
 	"github.com/thinkofdeath/goquake/render/gl"
 	"github.com/thinkofdeath/goquake/vmath"
-	"io/ioutil"
+	"image"
 	"math"
+	"sort"
 	"time"
 )
 
@@ -17,8 +20,25 @@ import (
 
 const (
 	atlasSize = 1024
+
+	// The player's physics is run at a fixed rate
+	physicsStep = 1.0 / 72.0
+
+	// Movement speeds, these match Quake's defaults
+	// whilst running
+	forwardSpeed = 400
+	sideSpeed    = 350
+	upSpeed      = 200
 )
 
+// Entities that the camera can be placed at when a level
+// is loaded, in order of preference.
+var spawnPoints = []string{
+	"info_player_start",
+	"info_player_deathmatch",
+	"info_player_coop",
+}
+
 var (
 	currentMap *qMap
 	pakFile    pak.File
@@ -30,18 +50,32 @@ var (
 
 	colourMap    gl.Texture
 	palette      gl.Texture
+	paletteData  *lmp.Palette
 	texture      gl.Texture
 	textureLight gl.Texture
-
-	gameShader    *mainShader
-	gameSkyShader *skyShader
-
-	cameraX       float64 = 504
-	cameraY       float64 = 401
-	cameraZ       float64 = 75
-	cameraRotY    float64
-	cameraRotX    float64 = math.Pi
-	movingForward bool
+	// RGB light atlas, only used by levels with coloured
+	// light maps
+	textureLightColour gl.Texture
+
+	gameShader       *mainShader
+	gameSkyShader    *skyShader
+	gameModelShader  *modelShader
+	gameSpriteShader *spriteShader
+
+	cameraX    float64
+	cameraY    float64
+	cameraZ    float64
+	cameraRotY float64
+	cameraRotX float64 = math.Pi
+
+	player      = movement.NewPlayer(vmath.Vector3{})
+	moveForward float32
+	moveSide    float32
+	jumping     bool
+	physicsTime float64
+
+	// Opacity of water, slime, lava and teleporters
+	waterAlpha float32 = 1
 )
 
 func Init(p pak.File) {
@@ -50,16 +84,23 @@ func Init(p pak.File) {
 	pakFile = p
 
 	// Load textures
-	cm, _ := ioutil.ReadAll(pakFile.Reader("gfx/colormap.lmp"))
+	cm, err := lmp.ParseColourMap(pakFile.Reader("gfx/colormap.lmp"))
+	if err != nil {
+		panic(err)
+	}
 	colourMap = createTexture(glTexture{
-		Data:  cm,
-		Width: 256, Height: 64,
+		Data:  cm[:],
+		Width: 256, Height: lmp.ColourMapLevels,
 		Format: gl.Red,
 	})
 
-	pm, _ := ioutil.ReadAll(pakFile.Reader("gfx/palette.lmp"))
+	pm, err := lmp.ParsePalette(pakFile.Reader("gfx/palette.lmp"))
+	if err != nil {
+		panic(err)
+	}
+	paletteData = pm
 	palette = createTexture(glTexture{
-		Data:  pm,
+		Data:  pm[:],
 		Width: 16, Height: 16,
 		Format: gl.RGB,
 	})
@@ -82,15 +123,27 @@ func Init(p pak.File) {
 		Filter: gl.Linear,
 	})
 
+	textureLightColour = createTexture(glTexture{
+		Data:  make([]byte, atlasSize*atlasSize*3),
+		Width: atlasSize, Height: atlasSize,
+		Format: gl.RGB,
+		Filter: gl.Linear,
+	})
+
 	gameShader = initMainShader()
 	gameSkyShader = initSkyShader()
+	gameModelShader = initModelShader()
+	gameSpriteShader = initSpriteShader()
 
-	initialMap, err := bsp.ParseBSPFile(p.Reader("maps/start.bsp"))
+	initialMap, err := loadLevel("start")
 	if err != nil {
 		panic(err)
 	}
-
-	currentMap = newQMap(initialMap)
+	currentMap, err = newQMap(initialMap)
+	if err != nil {
+		panic(err)
+	}
+	resetCamera(initialMap)
 	gl.ClearColor(0.0, 0.0, 0.0, 1.0)
 
 	gl.Enable(gl.DepthTest)
@@ -103,7 +156,7 @@ var lastFrame = time.Now()
 
 func Draw(width, height int) {
 	now := time.Now()
-	delta := float64(now.Sub(lastFrame).Nanoseconds()) / float64(time.Second/60)
+	physicsTime += now.Sub(lastFrame).Seconds()
 	lastFrame = now
 
 	if width != lastScreenWidth || height != lastScreenHeight {
@@ -122,11 +175,26 @@ func Draw(width, height int) {
 	}
 	gl.Clear(gl.ColorBufferBit | gl.DepthBufferBit)
 
-	if movingForward {
-		cameraX += 5.0 * math.Sin(cameraRotY) * delta
-		cameraY += 5.0 * math.Cos(cameraRotY) * delta
-		cameraZ -= 5.0 * math.Sin(-cameraRotX) * delta
+	// Don't try and catch up after long pauses (e.g.
+	// loading a level)
+	if physicsTime > 0.25 {
+		physicsTime = 0.25
 	}
+	cmd := movement.UserCmd{
+		ViewAngles:  viewAngles(),
+		ForwardMove: moveForward * forwardSpeed,
+		SideMove:    moveSide * sideSpeed,
+		Jump:        jumping,
+	}
+	if jumping {
+		cmd.UpMove = upSpeed
+	}
+	for ; physicsTime >= physicsStep; physicsTime -= physicsStep {
+		player.Move(currentMap.bsp, cmd, physicsStep)
+	}
+	cameraX = float64(player.Origin.X)
+	cameraY = float64(player.Origin.Y)
+	cameraZ = float64(player.Origin.Z + movement.ViewHeight)
 
 	cameraMatrix.Identity()
 	cameraMatrix.Translate(-float32(cameraX), -float32(cameraY), -float32(cameraZ))
@@ -138,12 +206,53 @@ func Draw(width, height int) {
 	currentMap.render()
 }
 
-func MoveForward() {
-	movingForward = true
+// SetMovement sets the direction the player is trying to
+// move in. forward and side should be between -1 and 1
+// with positive values moving forwards and to the right.
+func SetMovement(forward, side float32) {
+	moveForward = forward
+	moveSide = side
+}
+
+// SetJump sets whether the jump button is held. This also
+// moves upwards when swimming or flying.
+func SetJump(held bool) {
+	jumping = held
+}
+
+// SetWaterAlpha sets the opacity of liquids like Quake's
+// r_wateralpha, between 0 and 1. Most maps' visibility
+// information doesn't include what can be seen through
+// water so parts of the level may be missing below it.
+func SetWaterAlpha(alpha float32) {
+	waterAlpha = float32(math.Max(0, math.Min(1, float64(alpha))))
+}
+
+// ToggleNoClip switches between walking and flying through
+// walls.
+func ToggleNoClip() {
+	player.NoClip = !player.NoClip
+	player.Velocity = vmath.Vector3{}
 }
 
-func StopMove() {
-	movingForward = false
+// viewAngles returns the camera's rotation as Quake's
+// pitch, yaw and roll angles in degrees.
+func viewAngles() vmath.Vector3 {
+	return vmath.Vector3{
+		X: float32((cameraRotX - math.Pi) * (180 / math.Pi)),
+		Y: float32((math.Pi/2 - cameraRotY) * (180 / math.Pi)),
+	}
+}
+
+// ViewPoint returns the point the passed distance in front
+// of the camera.
+func ViewPoint(distance float32) vmath.Vector3 {
+	forward, _, _ := vmath.AngleVectors(viewAngles())
+	return vmath.Vector3{
+		X: float32(cameraX),
+		Y: float32(cameraY),
+		Z: float32(cameraZ),
+	}.Add(forward.Scale(distance))
 }
 
 func Rotate(x, y float64) {
@@ -151,14 +260,134 @@ func Rotate(x, y float64) {
 	cameraRotY += x
 }
 
-func SetLevel(name string) {
+// SetLevel loads the named level (e.g. e1m1) replacing
+// the current one. The current level is kept if the new
+// level fails to load.
+func SetLevel(name string) error {
 	start := time.Now()
-	currentMap.cleanup()
-	m, err := bsp.ParseBSPFile(pakFile.Reader("maps/" + name + ".bsp"))
+	m, err := loadLevel(name)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	q, err := newQMap(m)
+	if err != nil {
+		return fmt.Errorf("level %s: %s", name, err)
 	}
 
-	currentMap = newQMap(m)
+	currentMap.cleanup()
+	currentMap = q
+	resetCamera(m)
 	fmt.Println(time.Now().Sub(start))
+	return nil
+}
+
+// loadLevel reads the named level from the pak file along
+// with its .lit file and converts any textures with their
+// own palette to Quake's.
+func loadLevel(name string) (*bsp.File, error) {
+	r := pakFile.Reader("maps/" + name + ".bsp")
+	if r == nil {
+		return nil, fmt.Errorf("level %s not found", name)
+	}
+	m, err := bsp.ParseBSPFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("level %s: %s", name, err)
+	}
+	loadLit(m, name)
+	for _, t := range m.Textures {
+		// Half-Life maps
+		if t != nil {
+			t.RemapPalette(paletteData[:])
+		}
+	}
+	return m, nil
+}
+
+// ReplaceTextures replaces the current level's textures
+// with the images, keyed by texture name, and rebuilds the
+// level to show them. Images for textures the level
+// doesn't use are ignored. Textures that fail to convert
+// or are too large for the texture atlas are skipped and
+// the first error is returned. If the level can't be
+// rebuilt with the new textures, e.g. because they don't
+// all fit in the atlas, the old textures are kept.
+func ReplaceTextures(images map[string]image.Image) error {
+	// Sorted so the error returned doesn't depend on the
+	// map's order
+	var names []string
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := currentMap.bsp
+	old := make([]bsp.Texture, len(b.Textures))
+	for i, t := range b.Textures {
+		if t != nil {
+			old[i] = *t
+		}
+	}
+
+	var firstErr error
+	replaced := false
+	for _, name := range names {
+		if b.Texture(name) == nil {
+			continue
+		}
+		img := images[name]
+		var err error
+		if size := img.Bounds().Size(); size.X > atlasSize || size.Y > atlasSize {
+			err = fmt.Errorf("%dx%d is larger than the texture atlas", size.X, size.Y)
+		} else {
+			err = b.ReplaceTexture(name, img, paletteData[:])
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("texture %s: %s", name, err)
+			}
+			continue
+		}
+		replaced = true
+	}
+	if replaced {
+		q, err := newQMap(b)
+		if err != nil {
+			for i, t := range b.Textures {
+				if t != nil {
+					*t = old[i]
+				}
+			}
+			return err
+		}
+		currentMap.cleanup()
+		currentMap = q
+	}
+	return firstErr
+}
+
+// resetCamera moves the camera to the level's spawn point
+func resetCamera(m *bsp.File) {
+	noClip := player.NoClip
+	player = movement.NewPlayer(vmath.Vector3{})
+	player.NoClip = noClip
+	cameraRotX = math.Pi
+	cameraRotY = 0
+	for _, class := range spawnPoints {
+		ents := m.FindEntities(class)
+		if len(ents) == 0 {
+			continue
+		}
+		origin, ok := ents[0].Origin()
+		if !ok {
+			continue
+		}
+		player = movement.NewPlayer(origin)
+		player.NoClip = noClip
+		if angle, ok := ents[0].Angle(); ok && angle >= 0 {
+			// Quake's yaw starts at +X and goes counter clockwise
+			// where the camera's starts at +Y and goes clockwise
+			cameraRotY = math.Pi/2 - float64(angle)*(math.Pi/180)
+		}
+		return
+	}
 }
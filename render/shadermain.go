@@ -2,6 +2,7 @@ package render
 
 import (
 	"github.com/thinkofdeath/goquake/render/gl"
+	"time"
 )
 
 type mainShader struct {
@@ -12,14 +13,26 @@ type mainShader struct {
 	TexturePos        gl.Attribute `gl:"a_tex"`
 	TextureInfo       gl.Attribute `gl:"a_texInfo"`
 	LightInfo         gl.Attribute `gl:"a_lightInfo"`
-	LightType         gl.Attribute `gl:"a_lightType"`
+	LightStyles       gl.Attribute `gl:"a_lightStyles"`
+	LightHeight       gl.Attribute `gl:"a_lightHeight"`
+	Animation         gl.Attribute `gl:"a_animation"`
+	Turbulent         gl.Attribute `gl:"a_turbulent"`
 	PerspectiveMatrix gl.Uniform   `gl:"pMat"`
 	CameraMatrix      gl.Uniform   `gl:"uMat"`
+	ModelMatrix       gl.Uniform   `gl:"mMat"`
 	ColourMap         gl.Uniform   `gl:"colourMap"`
 	Palette           gl.Uniform   `gl:"palette"`
 	Texture           gl.Uniform   `gl:"texture"`
 	TextureLight      gl.Uniform   `gl:"textureLight"`
-	LightStyles       gl.Uniform   `gl:"lightStyles"`
+	LightColour       gl.Uniform   `gl:"textureLightColour"`
+	ColouredLight     gl.Uniform   `gl:"colouredLight"`
+	StyleValues       gl.Uniform   `gl:"lightStyles"`
+	AnimOffsets       gl.Uniform   `gl:"animOffsets"`
+	Time              gl.Uniform   `gl:"time"`
+	Alpha             gl.Uniform   `gl:"alpha"`
+	LightPositions    gl.Uniform   `gl:"dlights"`
+	LightColours      gl.Uniform   `gl:"dlightColours"`
+	LightCount        gl.Uniform   `gl:"dlightCount"`
 }
 
 func initMainShader() *mainShader {
@@ -34,6 +47,8 @@ func (m *mainShader) bind() {
 	m.program.Use()
 	m.PerspectiveMatrix.Matrix4(false, perspectiveMatrix)
 	m.CameraMatrix.Matrix4(false, cameraMatrix)
+	m.ModelMatrix.Matrix4(false, identityMatrix)
+	m.Time.Float(float32(time.Now().UnixNano()%int64(time.Hour)) / float32(time.Second))
 
 	// Bind textures
 
@@ -52,6 +67,15 @@ func (m *mainShader) bind() {
 	gl.ActiveTexture(3)
 	textureLight.Bind(gl.Texture2D)
 	m.TextureLight.Int(3)
+
+	gl.ActiveTexture(4)
+	textureLightColour.Bind(gl.Texture2D)
+	m.LightColour.Int(4)
+	if currentMap != nil && currentMap.colouredLight != nil {
+		m.ColouredLight.Int(1)
+	} else {
+		m.ColouredLight.Int(0)
+	}
 }
 
 func (m *mainShader) setupPointers(stride int) {
@@ -60,14 +84,20 @@ func (m *mainShader) setupPointers(stride int) {
 	m.TexturePos.Enable()
 	m.TextureInfo.Enable()
 	m.LightInfo.Enable()
-	m.LightType.Enable()
+	m.LightStyles.Enable()
+	m.Animation.Enable()
+	m.Turbulent.Enable()
+	m.LightHeight.Enable()
 
 	m.Position.Pointer(3, gl.Float, false, stride, 0)
 	m.TexturePos.Pointer(2, gl.UnsignedShort, false, stride, 4*3)
 	m.TextureInfo.Pointer(4, gl.Short, false, stride, 4*3+2*2)
 	m.LightInfo.Pointer(2, gl.Short, false, stride, 4*3+2*6)
 	m.Light.Pointer(1, gl.UnsignedByte, false, stride, 4*3+2*8)
-	m.LightType.Pointer(1, gl.UnsignedByte, false, stride, 4*3+2*8+1)
+	m.LightStyles.Pointer(4, gl.UnsignedByte, false, stride, 4*3+2*8+1)
+	m.Animation.Pointer(1, gl.UnsignedByte, false, stride, 4*3+2*8+5)
+	m.Turbulent.Pointer(1, gl.UnsignedByte, false, stride, 4*3+2*8+6)
+	m.LightHeight.Pointer(1, gl.UnsignedByte, false, stride, 4*3+2*8+7)
 }
 
 func (m *mainShader) unbind() {
@@ -81,35 +111,51 @@ in float a_light;
 in vec2 a_tex;
 in vec4 a_texInfo;
 in vec2 a_lightInfo;
-in float a_lightType;
+in vec4 a_lightStyles;
+in float a_animation;
+in float a_turbulent;
+in float a_lightHeight;
 
 uniform mat4 pMat;
 uniform mat4 uMat;
-uniform float lightStyles[11];
+uniform mat4 mMat;
+// Current brightness of each light style
+uniform float lightStyles[64];
+// Atlas position of the current frame of each animated
+// texture
+uniform vec2 animOffsets[64];
 
 out vec2 v_tex;
 out vec4 v_texInfo;
 out float v_light;
 out vec2 v_lightInfo;
-out float v_lightType;
+out vec4 v_lightScales;
+out float v_lightHeight;
+out float v_turbulent;
+out vec3 v_position;
 
 const float invTextureSize = 1.0 / 1024.0;
 const float invPackSize = 1.0;
 
 void main() {
-  gl_Position = pMat * uMat * vec4(a_position, 1.0);
+  vec4 position = mMat * vec4(a_position, 1.0);
+  gl_Position = pMat * uMat * position;
   v_tex = a_tex;
+  int anim = int(a_animation + 0.5);
+  if (anim > 0) {
+    v_tex = animOffsets[anim];
+  }
   v_texInfo = a_texInfo * invPackSize;
   v_light = a_light / 255.0;
   v_lightInfo = a_lightInfo * invTextureSize;
-  v_lightType = 1.0;
-  int type = int(a_lightType + 0.5);
-  for (int i = 0; i < 11; i++) {
-    if (type - 1 == i) {
-      v_lightType *= 1.0 - lightStyles[i];
-    }
+  v_turbulent = a_turbulent;
+  v_position = position.xyz;
+  v_lightHeight = a_lightHeight * invTextureSize;
+  for (int i = 0; i < 4; i++) {
+    int style = int(a_lightStyles[i] + 0.5);
+    // Unused styles are 255
+    v_lightScales[i] = style < 64 ? lightStyles[style] : 0.0;
   }
-
 }
 `
 	gameFragmentSource = `
@@ -120,12 +166,26 @@ uniform sampler2D palette;
 uniform sampler2D colourMap;
 uniform sampler2D texture;
 uniform sampler2D textureLight;
+// RGB version of textureLight, only valid when
+// colouredLight is set
+uniform sampler2D textureLightColour;
+uniform bool colouredLight;
+uniform float time;
+uniform float alpha;
+// Dynamic lights, the position and radius of each light
+// followed by its colour
+uniform vec4 dlights[8];
+uniform vec3 dlightColours[8];
+uniform int dlightCount;
 
 in vec2 v_tex;
 in vec4 v_texInfo;
 in float v_light;
 in vec2 v_lightInfo;
-in float v_lightType;
+in vec4 v_lightScales;
+in float v_lightHeight;
+in float v_turbulent;
+in vec3 v_position;
 
 out vec4 fragColor;
 
@@ -136,13 +196,50 @@ vec4 sampleTex(vec2 tOffset, vec2 tUV, vec2 tSize, sampler2D atlas);
 
 void main() {
   float light = 1.0 - v_light;
+  vec3 tint = vec3(1.0);
   if (v_lightInfo.x >= 0.0) {
-    light = light - (texture2D(textureLight, v_lightInfo).r);
+    float mono = 0.0;
+    vec3 rgb = vec3(0.0);
+    // Sum the light map of each style, these are stacked
+    // in the atlas
+    for (int i = 0; i < 4; i++) {
+      vec2 pos = v_lightInfo + vec2(0.0, v_lightHeight * float(i));
+      mono += texture2D(textureLight, pos).r * v_lightScales[i];
+      if (colouredLight) {
+        rgb += texture2D(textureLightColour, pos).rgb * v_lightScales[i];
+      }
+    }
+    // Like Quake dynamic lights only affect surfaces with
+    // light maps
+    for (int i = 0; i < dlightCount; i++) {
+      float amount = dlights[i].w - distance(v_position, dlights[i].xyz);
+      if (amount > 0.0) {
+        mono += amount / 255.0 * dot(dlightColours[i], vec3(1.0 / 3.0));
+        rgb += amount / 255.0 * dlightColours[i];
+      }
+    }
+    light -= mono;
+    // The colour map handles the brightness so the
+    // coloured light only changes the hue
+    if (colouredLight) {
+      tint = rgb / max(mono, 1.0 / 255.0);
+    }
   }
-  light *= v_lightType;
-  vec2 offset = mod(v_texInfo.xy, v_texInfo.zw);
+  vec2 texel = v_texInfo.xy;
+  if (v_turbulent > 0.5) {
+    // Quake's warp for liquids, each axis is offset by a
+    // sine wave along the other
+    texel += 8.0 * sin(texel.yx * 0.125 + time);
+  }
+  vec2 offset = mod(texel, v_texInfo.zw);
   float col = textureLod(texture, (v_tex.xy + offset) * invTextureSize, 4.0 - gl_FragCoord.w * 3000.0).r;
-  fragColor = vec4(lookupColour(col, light), 1.0);
+  vec3 colour = lookupColour(col, light);
+  // The last 32 colours of the palette are fullbright and
+  // aren't lit
+  if (col * 255.0 < 223.5) {
+    colour *= tint;
+  }
+  fragColor = vec4(colour, alpha);
 }
 
 vec3 lookupColour(float col, float light) {
@@ -0,0 +1,124 @@
+package render
+
+import (
+	"github.com/thinkofdeath/goquake/render/gl"
+)
+
+type modelShader struct {
+	program gl.Program
+
+	Position          gl.Attribute `gl:"a_position"`
+	NextPosition      gl.Attribute `gl:"a_nextPosition"`
+	TexturePos        gl.Attribute `gl:"a_tex"`
+	PerspectiveMatrix gl.Uniform   `gl:"pMat"`
+	CameraMatrix      gl.Uniform   `gl:"uMat"`
+	ModelMatrix       gl.Uniform   `gl:"mMat"`
+	Lerp              gl.Uniform   `gl:"lerp"`
+	SkinOffset        gl.Uniform   `gl:"skinOffset"`
+	Light             gl.Uniform   `gl:"light"`
+	ColourMap         gl.Uniform   `gl:"colourMap"`
+	Palette           gl.Uniform   `gl:"palette"`
+	Texture           gl.Uniform   `gl:"texture"`
+}
+
+func initModelShader() *modelShader {
+	m := &modelShader{}
+	m.program = compileProgram(modelVertexSource, modelFragmentSource)
+
+	loadShaderAttribsUniforms(m, m.program)
+	return m
+}
+
+func (m *modelShader) bind() {
+	m.program.Use()
+	m.PerspectiveMatrix.Matrix4(false, perspectiveMatrix)
+	m.CameraMatrix.Matrix4(false, cameraMatrix)
+
+	// Bind textures
+
+	gl.ActiveTexture(0)
+	palette.Bind(gl.Texture2D)
+	m.Palette.Int(0)
+
+	gl.ActiveTexture(1)
+	colourMap.Bind(gl.Texture2D)
+	m.ColourMap.Int(1)
+
+	gl.ActiveTexture(2)
+	texture.Bind(gl.Texture2D)
+	m.Texture.Int(2)
+}
+
+// setupTexturePointer sets up the skin coordinates from
+// the currently bound buffer.
+func (m *modelShader) setupTexturePointer() {
+	m.TexturePos.Enable()
+	m.TexturePos.Pointer(2, gl.Float, false, 4*2, 0)
+}
+
+// setupPositionPointers points the positions at the two
+// frames to blend between in the currently bound buffer.
+// The offsets are in vertices.
+func (m *modelShader) setupPositionPointers(frame, nextFrame int) {
+	m.Position.Enable()
+	m.NextPosition.Enable()
+
+	m.Position.Pointer(3, gl.Float, false, 4*3, frame*4*3)
+	m.NextPosition.Pointer(3, gl.Float, false, 4*3, nextFrame*4*3)
+}
+
+func (m *modelShader) unbind() {
+}
+
+const (
+	modelVertexSource = `
+#version 130
+in vec3 a_position;
+in vec3 a_nextPosition;
+in vec2 a_tex;
+
+uniform mat4 pMat;
+uniform mat4 uMat;
+uniform mat4 mMat;
+uniform float lerp;
+uniform vec2 skinOffset;
+
+out vec2 v_tex;
+
+const float invTextureSize = 1.0 / 1024.0;
+
+void main() {
+  vec3 pos = mix(a_position, a_nextPosition, lerp);
+  gl_Position = pMat * uMat * mMat * vec4(pos, 1.0);
+  v_tex = (a_tex + skinOffset) * invTextureSize;
+}
+`
+	modelFragmentSource = `
+#version 130
+precision mediump float;
+
+uniform sampler2D palette;
+uniform sampler2D colourMap;
+uniform sampler2D texture;
+uniform float light;
+
+in vec2 v_tex;
+
+out vec4 fragColor;
+
+vec3 lookupColour(float col, float light);
+
+void main() {
+  float col = textureLod(texture, v_tex, 0.0).r;
+  fragColor = vec4(lookupColour(col, light), 1.0);
+}
+
+vec3 lookupColour(float col, float light) {
+  float index = texture2D(colourMap, vec2(col, light)).r;
+  index = floor(index * 255.0 + 0.5);
+  float x = floor(mod(index, 16.0)) / 16.0;
+  float y = floor(index / 16.0) / 16.0;
+  return texture2D(palette, vec2(x, y)).rgb;
+}
+`
+)
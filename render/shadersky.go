@@ -15,6 +15,7 @@ type skyShader struct {
 	LightType         gl.Attribute `gl:"a_lightType"`
 	PerspectiveMatrix gl.Uniform   `gl:"pMat"`
 	CameraMatrix      gl.Uniform   `gl:"uMat"`
+	ModelMatrix       gl.Uniform   `gl:"mMat"`
 	ColourMap         gl.Uniform   `gl:"colourMap"`
 	Palette           gl.Uniform   `gl:"palette"`
 	Texture           gl.Uniform   `gl:"texture"`
@@ -34,6 +35,7 @@ func (m *skyShader) bind() {
 	m.program.Use()
 	m.PerspectiveMatrix.Matrix4(false, perspectiveMatrix)
 	m.CameraMatrix.Matrix4(false, cameraMatrix)
+	m.ModelMatrix.Matrix4(false, identityMatrix)
 
 	// Bind textures
 
@@ -85,6 +87,7 @@ in float a_lightType;
 
 uniform mat4 pMat;
 uniform mat4 uMat;
+uniform mat4 mMat;
 uniform float lightStyles[11];
 
 out vec2 v_tex;
@@ -98,12 +101,13 @@ const float invTextureSize = 1.0 / 1024.0;
 const float invPackSize = 1.0;
 
 void main() {
-  gl_Position = pMat * uMat * vec4(a_position, 1.0);
+  vec4 position = mMat * vec4(a_position, 1.0);
+  gl_Position = pMat * uMat * position;
   v_tex = a_tex;
   v_texInfo = a_texInfo * invPackSize;
   v_light = a_light / 255.0;
   v_lightInfo = a_lightInfo * invTextureSize;
-  v_pos = a_position.xy / 4096.0;
+  v_pos = position.xy / 4096.0;
   v_lightType = a_lightType;
 }
 `
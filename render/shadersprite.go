@@ -0,0 +1,107 @@
+package render
+
+import (
+	"github.com/thinkofdeath/goquake/render/gl"
+)
+
+type spriteShader struct {
+	program gl.Program
+
+	Position          gl.Attribute `gl:"a_position"`
+	TexturePos        gl.Attribute `gl:"a_tex"`
+	PerspectiveMatrix gl.Uniform   `gl:"pMat"`
+	CameraMatrix      gl.Uniform   `gl:"uMat"`
+	ColourMap         gl.Uniform   `gl:"colourMap"`
+	Palette           gl.Uniform   `gl:"palette"`
+	Texture           gl.Uniform   `gl:"texture"`
+}
+
+func initSpriteShader() *spriteShader {
+	m := &spriteShader{}
+	m.program = compileProgram(spriteVertexSource, spriteFragmentSource)
+
+	loadShaderAttribsUniforms(m, m.program)
+	return m
+}
+
+func (m *spriteShader) bind() {
+	m.program.Use()
+	m.PerspectiveMatrix.Matrix4(false, perspectiveMatrix)
+	m.CameraMatrix.Matrix4(false, cameraMatrix)
+
+	// Bind textures
+
+	gl.ActiveTexture(0)
+	palette.Bind(gl.Texture2D)
+	m.Palette.Int(0)
+
+	gl.ActiveTexture(1)
+	colourMap.Bind(gl.Texture2D)
+	m.ColourMap.Int(1)
+
+	gl.ActiveTexture(2)
+	texture.Bind(gl.Texture2D)
+	m.Texture.Int(2)
+}
+
+func (m *spriteShader) setupPointers(stride int) {
+	m.Position.Enable()
+	m.TexturePos.Enable()
+
+	m.Position.Pointer(3, gl.Float, false, stride, 0)
+	m.TexturePos.Pointer(2, gl.Float, false, stride, 4*3)
+}
+
+func (m *spriteShader) unbind() {
+}
+
+const (
+	spriteVertexSource = `
+#version 130
+in vec3 a_position;
+in vec2 a_tex;
+
+uniform mat4 pMat;
+uniform mat4 uMat;
+
+out vec2 v_tex;
+
+const float invTextureSize = 1.0 / 1024.0;
+
+void main() {
+  gl_Position = pMat * uMat * vec4(a_position, 1.0);
+  v_tex = a_tex * invTextureSize;
+}
+`
+	spriteFragmentSource = `
+#version 130
+precision mediump float;
+
+uniform sampler2D palette;
+uniform sampler2D colourMap;
+uniform sampler2D texture;
+
+in vec2 v_tex;
+
+out vec4 fragColor;
+
+vec3 lookupColour(float col, float light);
+
+void main() {
+  float col = textureLod(texture, v_tex, 0.0).r;
+  // Index 255 is transparent
+  if (floor(col * 255.0 + 0.5) == 255.0) {
+    discard;
+  }
+  fragColor = vec4(lookupColour(col, 0.5), 1.0);
+}
+
+vec3 lookupColour(float col, float light) {
+  float index = texture2D(colourMap, vec2(col, light)).r;
+  index = floor(index * 255.0 + 0.5);
+  float x = floor(mod(index, 16.0)) / 16.0;
+  float y = floor(index / 16.0) / 16.0;
+  return texture2D(palette, vec2(x, y)).rgb;
+}
+`
+)
@@ -2,9 +2,10 @@ package render
 
 import (
 	"github.com/thinkofdeath/goquake/bsp"
+	"github.com/thinkofdeath/goquake/vmath"
 )
 
-// Used for sorting textures/lightmaps
+// Used for sorting textures/lightmaps/draw ranges
 
 type ti struct {
 	id      int
@@ -28,6 +29,11 @@ func (t tiSorter) Swap(i, j int) {
 type li struct {
 	id  int
 	pic *bsp.Picture
+	// Height of each style's light map within pic
+	layerHeight int
+	// RGB version of pic's data, nil if the level has no
+	// coloured light maps
+	colour []byte
 }
 
 type liSorter []li
@@ -43,3 +49,38 @@ func (l liSorter) Less(i, j int) bool {
 func (l liSorter) Swap(i, j int) {
 	l[i], l[j] = l[j], l[i]
 }
+
+type rangeSorter []drawRange
+
+func (r rangeSorter) Len() int {
+	return len(r)
+}
+
+func (r rangeSorter) Less(i, j int) bool {
+	return r[i].offset < r[j].offset
+}
+
+func (r rangeSorter) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+// waterSorter sorts water faces furthest from the camera
+// first
+type waterSorter struct {
+	faces  []waterFace
+	camera vmath.Vector3
+}
+
+func (w waterSorter) Len() int {
+	return len(w.faces)
+}
+
+func (w waterSorter) Less(i, j int) bool {
+	a := w.faces[i].position.Sub(w.camera)
+	b := w.faces[j].position.Sub(w.camera)
+	return a.Dot(a) > b.Dot(b)
+}
+
+func (w waterSorter) Swap(i, j int) {
+	w.faces[i], w.faces[j] = w.faces[j], w.faces[i]
+}
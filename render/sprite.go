@@ -0,0 +1,254 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"github.com/thinkofdeath/goquake/render/atlas"
+	"github.com/thinkofdeath/goquake/render/builder"
+	"github.com/thinkofdeath/goquake/render/gl"
+	"github.com/thinkofdeath/goquake/sprite"
+	"github.com/thinkofdeath/goquake/vmath"
+	"math"
+	"time"
+)
+
+// Sprites that are loaded with every level so that they
+// can be added at any time. Sprites missing from the pak
+// files or that don't fit in the atlas are skipped.
+var precacheSprites = []string{
+	"progs/s_explod.spr",
+	"progs/s_bubble.spr",
+	"progs/s_light.spr",
+	"progs/s_spike.spr",
+}
+
+// Rate that sprite frames are played at
+const spriteFrameRate = 10
+
+var (
+	spriteVertexSerializer func(*builder.Buffer, interface{})
+	spriteVertexTypes      []builder.Type
+
+	// ErrUnknownSprite is returned when adding a sprite that
+	// hasn't been loaded
+	ErrUnknownSprite = errors.New("unknown sprite")
+)
+
+type spriteVertex struct {
+	X        float32
+	Y        float32
+	Z        float32
+	TextureX float32
+	TextureY float32
+}
+
+func init() {
+	spriteVertexSerializer, spriteVertexTypes = builder.Struct(spriteVertex{})
+}
+
+// qSprite is a sprite with its frames added to the atlas
+type qSprite struct {
+	sprite *sprite.Sprite
+	// frames contains the location of every frame of
+	// each frame group in the atlas.
+	frames [][]*atlas.Rect
+}
+
+// spriteEntity is an instance of a sprite placed in the
+// level.
+type spriteEntity struct {
+	sprite *qSprite
+	origin vmath.Vector3
+	angles vmath.Vector3
+	start  time.Time
+	loop   bool
+}
+
+// loadSprites adds all precached sprites to the atlas.
+// This must be called before the atlas is baked.
+func (m *qMap) loadSprites() {
+	m.sprites = map[string]*qSprite{}
+sprites:
+	for _, name := range precacheSprites {
+		r := pakFile.Reader(name)
+		if r == nil {
+			continue
+		}
+		s, err := sprite.ParseSPRFile(r)
+		if err != nil {
+			fmt.Printf("failed to load %s: %s\n", name, err)
+			continue
+		}
+		if len(s.Frames) == 0 {
+			continue
+		}
+
+		q := &qSprite{
+			sprite: s,
+			frames: make([][]*atlas.Rect, len(s.Frames)),
+		}
+		for i, group := range s.Frames {
+			for _, f := range group.Frames {
+				r, err := m.atlas.Add(f.Picture)
+				if err != nil {
+					fmt.Printf("skipping %s: %s\n", name, err)
+					continue sprites
+				}
+				q.frames[i] = append(q.frames[i], r)
+			}
+		}
+		m.sprites[name] = q
+	}
+}
+
+// initSprites creates the buffer that sprites are drawn
+// from.
+func (m *qMap) initSprites() {
+	m.spriteVertexArray = gl.CreateVertexArray()
+	m.spriteVertexArray.Bind()
+	m.spriteBuffer = gl.CreateBuffer()
+	m.spriteBuffer.Bind(gl.ArrayBuffer)
+	gameSpriteShader.setupPointers(builder.New(spriteVertexTypes...).ElementSize())
+}
+
+// AddSprite places a sprite into the current level. The
+// sprite's animation is either looped forever or played
+// once after which the sprite is removed. angles are only
+// used by oriented sprites.
+func AddSprite(name string, origin, angles vmath.Vector3, loop bool) error {
+	s, ok := currentMap.sprites[name]
+	if !ok {
+		return ErrUnknownSprite
+	}
+	currentMap.spriteEntities = append(currentMap.spriteEntities, &spriteEntity{
+		sprite: s,
+		origin: origin,
+		angles: angles,
+		start:  time.Now(),
+		loop:   loop,
+	})
+	return nil
+}
+
+// currentFrame returns the frame to display at the passed
+// time, false is returned once a sprite that doesn't loop
+// has finished.
+func (e *spriteEntity) currentFrame(now time.Time) (*sprite.Frame, *atlas.Rect, bool) {
+	s := e.sprite
+	t := now.Sub(e.start).Seconds()
+	index := int(t * spriteFrameRate)
+	if index >= len(s.frames) {
+		if !e.loop {
+			return nil, nil, false
+		}
+		index %= len(s.frames)
+	}
+
+	group := s.sprite.Frames[index]
+	i := 0
+	// Frame groups animate on their own
+	if len(group.Frames) > 1 {
+		total := float64(group.Intervals[len(group.Intervals)-1])
+		if total > 0 {
+			gt := math.Mod(t, total)
+			for i < len(group.Intervals)-1 && gt >= float64(group.Intervals[i]) {
+				i++
+			}
+		}
+	}
+	return group.Frames[i], s.frames[index][i], true
+}
+
+// orientation returns the right and up vectors of the
+// sprite's plane.
+func (e *spriteEntity) orientation(camera, viewRight, viewUp vmath.Vector3) (right, up vmath.Vector3) {
+	switch e.sprite.sprite.Orientation {
+	case sprite.FacingUpright:
+		// Face the camera's position instead of the view
+		// plane
+		dir, _ := e.origin.Sub(camera).Normalize()
+		right, _ = vmath.Vector3{X: dir.Y, Y: -dir.X}.Normalize()
+		up = vmath.Vector3{Z: 1}
+	case sprite.Parallel:
+		right, up = viewRight, viewUp
+	case sprite.Oriented:
+		_, right, up = vmath.AngleVectors(e.angles)
+	case sprite.ParallelOriented:
+		sr, cr := math.Sincos(float64(e.angles.Z) * (math.Pi / 180))
+		right = viewRight.Scale(float32(cr)).Add(viewUp.Scale(float32(sr)))
+		up = viewRight.Scale(float32(-sr)).Add(viewUp.Scale(float32(cr)))
+	default:
+		right = viewRight
+		up = vmath.Vector3{Z: 1}
+	}
+	return
+}
+
+// renderSprites draws every sprite in the level as a
+// billboard, removing sprites that have finished playing.
+func (m *qMap) renderSprites() {
+	if len(m.spriteEntities) == 0 {
+		return
+	}
+	now := time.Now()
+	camera := vmath.Vector3{
+		X: float32(cameraX),
+		Y: float32(cameraY),
+		Z: float32(cameraZ),
+	}
+	_, viewRight, viewUp := vmath.AngleVectors(viewAngles())
+
+	data := builder.New(spriteVertexTypes...)
+	alive := m.spriteEntities[:0]
+	for _, e := range m.spriteEntities {
+		frame, rect, ok := e.currentFrame(now)
+		if !ok {
+			continue
+		}
+		alive = append(alive, e)
+
+		right, up := e.orientation(camera, viewRight, viewUp)
+		left := float32(frame.OriginX)
+		top := float32(frame.OriginY)
+		rightEdge := left + float32(frame.Picture.Width)
+		bottom := top - float32(frame.Picture.Height)
+
+		corner := func(x, y float32, tx, ty int) {
+			p := e.origin.Add(right.Scale(x)).Add(up.Scale(y))
+			spriteVertexSerializer(data, spriteVertex{
+				X:        p.X,
+				Y:        p.Y,
+				Z:        p.Z,
+				TextureX: float32(tx),
+				TextureY: float32(ty),
+			})
+		}
+		x0, y0 := rect.X, rect.Y
+		x1, y1 := rect.X+rect.Width, rect.Y+rect.Height
+		corner(left, top, x0, y0)
+		corner(left, bottom, x0, y1)
+		corner(rightEdge, bottom, x1, y1)
+		corner(left, top, x0, y0)
+		corner(rightEdge, bottom, x1, y1)
+		corner(rightEdge, top, x1, y0)
+	}
+	// Clear the removed sprites so they can be collected
+	for i := len(alive); i < len(m.spriteEntities); i++ {
+		m.spriteEntities[i] = nil
+	}
+	m.spriteEntities = alive
+
+	if data.Count() == 0 {
+		return
+	}
+
+	// Sprites can be viewed from either side
+	gl.Disable(gl.CullFaceFlag)
+	gameSpriteShader.bind()
+	m.spriteVertexArray.Bind()
+	m.spriteBuffer.Bind(gl.ArrayBuffer)
+	m.spriteBuffer.Data(data.Data(), gl.DynamicDraw)
+	gl.DrawArrays(gl.Triangles, 0, data.Count())
+	gameSpriteShader.unbind()
+	gl.Enable(gl.CullFaceFlag)
+}
@@ -0,0 +1,197 @@
+// Package sprite provides methods to read Quake's sprite
+// files
+package sprite
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/thinkofdeath/goquake/bsp"
+	"io"
+)
+
+const (
+	sprMagic   = "IDSP"
+	sprVersion = 1
+)
+
+var (
+	// ErrInvalid is returned when the sprite file is invalid
+	ErrInvalid = errors.New("Invalid SPR file")
+)
+
+// Orientation controls how a sprite is rotated to face
+// the viewer.
+type Orientation int
+
+// Possible orientations of a sprite
+const (
+	// ParallelUpright sprites face the view plane but stay
+	// upright.
+	ParallelUpright Orientation = iota
+	// FacingUpright sprites face the viewer's position but
+	// stay upright.
+	FacingUpright
+	// Parallel sprites always face the view plane.
+	Parallel
+	// Oriented sprites use the entity's angles and don't
+	// face the viewer.
+	Oriented
+	// ParallelOriented sprites face the view plane but are
+	// rolled by the entity's roll angle.
+	ParallelOriented
+)
+
+// Sprite is a flat, camera facing image made up of one or
+// more frames.
+type Sprite struct {
+	Orientation    Orientation
+	BoundingRadius float32
+	Width          int
+	Height         int
+	BeamLength     float32
+	SyncType       int
+	Frames         []*FrameGroup
+}
+
+// Frame is a single image of the sprite. OriginX and
+// OriginY are the offset of the top left corner of the
+// picture from the sprite's origin, with OriginY going up.
+type Frame struct {
+	OriginX, OriginY int
+	Picture          *bsp.Picture
+}
+
+// FrameGroup is a frame of the sprite. Frames that are
+// animated on their own contain multiple frames with the
+// time (in seconds) that each one ends at.
+type FrameGroup struct {
+	Frames    []*Frame
+	Intervals []float32
+}
+
+// ParseSPRFile parses a sprite from the reader.
+func ParseSPRFile(r *io.SectionReader) (s *Sprite, err error) {
+	var header sprHeader
+	err = binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return
+	}
+
+	if string(header.Magic[:]) != sprMagic {
+		err = ErrInvalid
+		return
+	}
+	if header.Version != sprVersion {
+		err = errors.New("unsupported version")
+		return
+	}
+	if header.Type < 0 || header.Type > int32(ParallelOriented) || header.NumFrames < 0 {
+		err = ErrInvalid
+		return
+	}
+	// Every frame takes up at least 4 bytes of the file,
+	// checked before allocating space for them
+	if int64(header.NumFrames)*4 > r.Size() {
+		err = ErrInvalid
+		return
+	}
+
+	s = &Sprite{
+		Orientation:    Orientation(header.Type),
+		BoundingRadius: header.BoundingRadius,
+		Width:          int(header.Width),
+		Height:         int(header.Height),
+		BeamLength:     header.BeamLength,
+		SyncType:       int(header.SyncType),
+		Frames:         make([]*FrameGroup, header.NumFrames),
+	}
+
+	for i := range s.Frames {
+		var group int32
+		err = binary.Read(r, binary.LittleEndian, &group)
+		if err != nil {
+			return
+		}
+
+		if group == 0 {
+			var f *Frame
+			f, err = parseFrame(r)
+			if err != nil {
+				return
+			}
+			s.Frames[i] = &FrameGroup{Frames: []*Frame{f}}
+			continue
+		}
+
+		var num int32
+		err = binary.Read(r, binary.LittleEndian, &num)
+		if err != nil {
+			return
+		}
+		if num <= 0 || int64(num)*4 > r.Size() {
+			err = ErrInvalid
+			return
+		}
+		g := &FrameGroup{
+			Frames:    make([]*Frame, num),
+			Intervals: make([]float32, num),
+		}
+		err = binary.Read(r, binary.LittleEndian, g.Intervals)
+		if err != nil {
+			return
+		}
+		for j := range g.Frames {
+			g.Frames[j], err = parseFrame(r)
+			if err != nil {
+				return
+			}
+		}
+		s.Frames[i] = g
+	}
+	return
+}
+
+func parseFrame(r *io.SectionReader) (*Frame, error) {
+	var header frameData
+	err := binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Width < 0 || header.Height < 0 ||
+		int64(header.Width)*int64(header.Height) > r.Size() {
+		return nil, ErrInvalid
+	}
+
+	f := &Frame{
+		OriginX: int(header.Origin[0]),
+		OriginY: int(header.Origin[1]),
+		Picture: &bsp.Picture{
+			Width:  int(header.Width),
+			Height: int(header.Height),
+			Data:   make([]byte, int(header.Width)*int(header.Height)),
+		},
+	}
+	_, err = io.ReadFull(r, f.Picture.Data)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type sprHeader struct {
+	Magic          [4]byte
+	Version        int32
+	Type           int32
+	BoundingRadius float32
+	Width          int32
+	Height         int32
+	NumFrames      int32
+	BeamLength     float32
+	SyncType       int32
+}
+
+type frameData struct {
+	Origin [2]int32
+	Width  int32
+	Height int32
+}
@@ -0,0 +1,80 @@
+package sprite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// buildTestSPR creates a sprite containing a single 2x2
+// frame followed by a group of two 1x1 frames.
+func buildTestSPR() []byte {
+	var b bytes.Buffer
+	w := func(v ...interface{}) {
+		for _, x := range v {
+			binary.Write(&b, binary.LittleEndian, x)
+		}
+	}
+	header := sprHeader{Version: sprVersion, Width: 2, Height: 2, NumFrames: 2}
+	copy(header.Magic[:], sprMagic)
+	w(header)
+	w(int32(0), frameData{Origin: [2]int32{-1, 1}, Width: 2, Height: 2}, []byte{1, 2, 3, 4})
+	w(int32(1), int32(2), []float32{0.1, 0.2})
+	w(frameData{Width: 1, Height: 1}, []byte{5})
+	w(frameData{Width: 1, Height: 1}, []byte{6})
+	return b.Bytes()
+}
+
+func parseTestSPR(data []byte) (*Sprite, error) {
+	return ParseSPRFile(io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+}
+
+func TestParseFrames(t *testing.T) {
+	s, err := parseTestSPR(buildTestSPR())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Frames) != 2 || len(s.Frames[0].Frames) != 1 || len(s.Frames[1].Frames) != 2 {
+		t.Fatalf("unexpected frames %+v", s.Frames)
+	}
+	f := s.Frames[0].Frames[0]
+	if f.OriginX != -1 || f.OriginY != 1 || !bytes.Equal(f.Picture.Data, []byte{1, 2, 3, 4}) {
+		t.Errorf("unexpected frame %+v", f)
+	}
+	if s.Frames[1].Intervals[1] != 0.2 || s.Frames[1].Frames[1].Picture.Data[0] != 6 {
+		t.Errorf("unexpected group %+v", s.Frames[1])
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	data := buildTestSPR()
+	for _, n := range []int{10, binary.Size(sprHeader{}) + 4, len(data) - 1} {
+		if _, err := parseTestSPR(data[:n]); err == nil {
+			t.Errorf("%d bytes: expected an error", n)
+		}
+	}
+}
+
+func TestParseOversized(t *testing.T) {
+	headerSize := binary.Size(sprHeader{})
+	frameOffset := headerSize + 4
+	tests := []struct {
+		name   string
+		values map[int]int32
+	}{
+		// Overflows int32 when multiplied
+		{"frame size", map[int]int32{frameOffset + 8: 46341, frameOffset + 12: 46341}},
+		{"frame count", map[int]int32{headerSize - 12: 1 << 30}},
+		{"group count", map[int]int32{frameOffset + 16 + 4 + 4: 1 << 30}},
+	}
+	for _, test := range tests {
+		data := buildTestSPR()
+		for offset, v := range test.values {
+			binary.LittleEndian.PutUint32(data[offset:], uint32(v))
+		}
+		if _, err := parseTestSPR(data); err != ErrInvalid {
+			t.Errorf("%s: expected ErrInvalid, got %v", test.name, err)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 package vmath
 
+import (
+	"math"
+)
+
 type Vector3 struct {
 	X, Y, Z float32
 }
@@ -7,3 +11,89 @@ type Vector3 struct {
 func (v Vector3) Dot(other Vector3) float32 {
 	return v.X*other.X + v.Y*other.Y + v.Z*other.Z
 }
+
+// Index returns the component of the vector at the index
+// with 0, 1 and 2 being X, Y and Z respectively.
+func (v Vector3) Index(i int) float32 {
+	switch i {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	default:
+		return v.Z
+	}
+}
+
+// Add returns the sum of the two vectors
+func (v Vector3) Add(other Vector3) Vector3 {
+	return Vector3{v.X + other.X, v.Y + other.Y, v.Z + other.Z}
+}
+
+// Sub returns the result of subtracting other from this
+// vector
+func (v Vector3) Sub(other Vector3) Vector3 {
+	return Vector3{v.X - other.X, v.Y - other.Y, v.Z - other.Z}
+}
+
+// Scale returns the vector multiplied by s
+func (v Vector3) Scale(s float32) Vector3 {
+	return Vector3{v.X * s, v.Y * s, v.Z * s}
+}
+
+// Lerp returns the point the fraction t of the way between
+// this vector and other
+func (v Vector3) Lerp(other Vector3, t float32) Vector3 {
+	return v.Add(other.Sub(v).Scale(t))
+}
+
+// Length returns the length of the vector
+func (v Vector3) Length() float32 {
+	return float32(math.Sqrt(float64(v.Dot(v))))
+}
+
+// Normalize returns the vector scaled to a length of 1
+// and the original length of the vector. Zero length
+// vectors are returned unchanged.
+func (v Vector3) Normalize() (Vector3, float32) {
+	l := v.Length()
+	if l == 0 {
+		return v, 0
+	}
+	return v.Scale(1 / l), l
+}
+
+// Cross returns the cross product of the two vectors
+func (v Vector3) Cross(other Vector3) Vector3 {
+	return Vector3{
+		v.Y*other.Z - v.Z*other.Y,
+		v.Z*other.X - v.X*other.Z,
+		v.X*other.Y - v.Y*other.X,
+	}
+}
+
+// AngleVectors returns the forward, right and up vectors
+// for the angles (pitch, yaw, roll in degrees).
+func AngleVectors(angles Vector3) (forward, right, up Vector3) {
+	toRad := math.Pi / 180
+	sy, cy := math.Sincos(float64(angles.Y) * toRad)
+	sp, cp := math.Sincos(float64(angles.X) * toRad)
+	sr, cr := math.Sincos(float64(angles.Z) * toRad)
+
+	forward = Vector3{
+		X: float32(cp * cy),
+		Y: float32(cp * sy),
+		Z: float32(-sp),
+	}
+	right = Vector3{
+		X: float32(-sr*sp*cy + cr*sy),
+		Y: float32(-sr*sp*sy - cr*cy),
+		Z: float32(-sr * cp),
+	}
+	up = Vector3{
+		X: float32(cr*sp*cy + sr*sy),
+		Y: float32(cr*sp*sy - sr*cy),
+		Z: float32(cr * cp),
+	}
+	return
+}